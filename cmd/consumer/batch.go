@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/segmentio/kafka-go"
+)
+
+// batchWait is how long a poll waits for more messages once it has one.
+const batchWait = 10 * time.Millisecond
+
+// fetchBatch blocks for one message and then collects up to size-1 more
+// that arrive within batchWait. On shutdown it returns ctx's error and
+// drops the partial batch, which is redelivered as it was never committed.
+func fetchBatch(ctx context.Context, r *kafka.Reader, size int) ([]kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{m}
+	deadline := time.Now().Add(batchWait)
+	for len(batch) < size {
+		waitCtx, cancel := context.WithDeadline(ctx, deadline)
+		m, err := r.FetchMessage(waitCtx)
+		cancel()
+		if err != nil {
+			break
+		}
+		batch = append(batch, m)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return batch, nil
+}
+
+// processBatch applies a poll's messages with up to workers concurrent
+// Redis pipelines. Messages are sharded by driver ID, so every ping of one
+// driver goes through the same pipeline in offset order. It returns how
+// many leading messages of the batch were applied, dropped as stale or
+// dead-lettered and, when that is not all of them, why the next one was
+// neither; only that prefix may be committed.
+func processBatch(ctx context.Context, batch []kafka.Message, rc RedisUpdater, dlq messageWriter, workers int) (int, error) {
+	type item struct {
+		idx int
+		m   kafka.Message
+		d   *models.Driver
+	}
+	results := make([]error, len(batch))
+	shards := make([][]item, workers)
+	for idx, m := range batch {
+		d, err := decode(ctx, m, dlq)
+		if d == nil {
+			results[idx] = err
+			continue
+		}
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(d.ID))
+		i := h.Sum32() % uint32(workers)
+		shards[i] = append(shards[i], item{idx, m, d})
+	}
+
+	var wg sync.WaitGroup
+	for _, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(shard []item) {
+			defer wg.Done()
+			ds := make([]*models.Driver, len(shard))
+			for i, it := range shard {
+				ds[i] = it.d
+			}
+			errs := updateRedisBatchWithRetry(ctx, rc, ds, redisAttempts, retryDelay)
+			for i, it := range shard {
+				// each index belongs to one shard, so the writes don't race
+				results[it.idx] = record(ctx, it.m, it.d, errs[i], dlq)
+			}
+		}(shard)
+	}
+	wg.Wait()
+	for i, err := range results {
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
+
+// updateRedisBatchWithRetry applies the pings with ApplyLocations and
+// retries the failed ones with backoff. It returns each ping's outcome as
+// updateRedisWithRetry would.
+func updateRedisBatchWithRetry(ctx context.Context, rc RedisUpdater, ds []*models.Driver, attempts int, delay time.Duration) []error {
+	errs := make([]error, len(ds))
+	pending := make([]int, len(ds))
+	for i := range ds {
+		pending[i] = i
+	}
+	for attempt := 0; attempt < attempts && len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		batch := make([]*models.Driver, len(pending))
+		for j, i := range pending {
+			batch[j] = ds[i]
+		}
+		applied, results := rc.ApplyLocations(ctx, batch, time.Now())
+		retry := pending[:0]
+		for j, i := range pending {
+			switch {
+			case results[j] != nil:
+				errs[i] = results[j]
+				retry = append(retry, i)
+			case !applied[j]:
+				errs[i] = errStaleLocation
+			default:
+				errs[i] = nil
+			}
+		}
+		pending = retry
+	}
+	return errs
+}
@@ -3,47 +3,58 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/example/ride-matching/internal/ingest"
 	"github.com/example/ride-matching/internal/models"
-	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
 )
 
 // fakeUpdater implements RedisUpdater for tests
 type fakeUpdater struct {
-	failGeo  int // number of times to fail GeoAdd before succeeding
-	failH    int // number of times to fail HSet before succeeding
-	geoCalls int
-	hCalls   int
+	mu      sync.Mutex
+	fail    int // number of times to fail ApplyLocation before succeeding
+	stale   bool
+	calls   int
+	batches [][]*models.Driver // ApplyLocations calls
 }
 
-func (f *fakeUpdater) GeoAdd(ctx context.Context, key string, loc *redis.GeoLocation) error {
-	f.geoCalls++
-	if f.geoCalls <= f.failGeo {
-		return errors.New("geo fail")
+func (f *fakeUpdater) ApplyLocation(ctx context.Context, d *models.Driver, now time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.fail {
+		return false, errors.New("redis fail")
 	}
-	return nil
+	return !f.stale, nil
 }
 
-func (f *fakeUpdater) HSet(ctx context.Context, key string, values map[string]interface{}) error {
-	f.hCalls++
-	if f.hCalls <= f.failH {
-		return errors.New("hset fail")
+func (f *fakeUpdater) ApplyLocations(ctx context.Context, ds []*models.Driver, now time.Time) ([]bool, []error) {
+	f.mu.Lock()
+	f.batches = append(f.batches, ds)
+	f.mu.Unlock()
+	applied := make([]bool, len(ds))
+	errs := make([]error, len(ds))
+	for i, d := range ds {
+		applied[i], errs[i] = f.ApplyLocation(ctx, d, now)
 	}
-	return nil
+	return applied, errs
 }
 
 func TestUpdateRedisWithRetry_SucceedsAfterRetries(t *testing.T) {
-	f := &fakeUpdater{failGeo: 1, failH: 1}
+	f := &fakeUpdater{fail: 2}
 	d := &models.Driver{ID: "d1", Loc: models.Coord{Lat: 1, Lon: 2}, Rating: 4.5, Online: true}
 	ctx := context.Background()
 	start := time.Now()
 	if err := updateRedisWithRetry(ctx, f, d, 3, 10*time.Millisecond); err != nil {
 		t.Fatalf("expected success, got err=%v", err)
 	}
-	if f.geoCalls < 2 || f.hCalls < 2 {
-		t.Fatalf("expected retries, got geo=%d h=%d", f.geoCalls, f.hCalls)
+	if f.calls != 3 {
+		t.Fatalf("expected retries, got %d calls", f.calls)
 	}
 	if time.Since(start) < 10*time.Millisecond {
 		t.Fatalf("expected at least one backoff")
@@ -51,10 +62,183 @@ func TestUpdateRedisWithRetry_SucceedsAfterRetries(t *testing.T) {
 }
 
 func TestUpdateRedisWithRetry_FailsWhenExhausted(t *testing.T) {
-	f := &fakeUpdater{failGeo: 5, failH: 0}
+	f := &fakeUpdater{fail: 5}
 	d := &models.Driver{ID: "d1", Loc: models.Coord{Lat: 1, Lon: 2}, Rating: 4.5, Online: true}
 	ctx := context.Background()
 	if err := updateRedisWithRetry(ctx, f, d, 3, 5*time.Millisecond); err == nil {
 		t.Fatalf("expected error after retries")
 	}
 }
+
+func TestUpdateRedisWithRetry_StaleIsNotRetried(t *testing.T) {
+	f := &fakeUpdater{stale: true}
+	d := &models.Driver{ID: "d1", Seq: 1}
+	if err := updateRedisWithRetry(context.Background(), f, d, 3, time.Millisecond); !errors.Is(err, errStaleLocation) {
+		t.Fatalf("expected errStaleLocation, got %v", err)
+	}
+	if f.calls != 1 {
+		t.Fatalf("stale ping retried %d times", f.calls)
+	}
+}
+
+type fakeWriter struct {
+	msgs []kafka.Message
+	err  error // returned instead of writing when set
+}
+
+func (f *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+
+func header(m kafka.Message, key string) string {
+	v, _ := headerValue(m, key)
+	return v
+}
+
+func TestProcessDeadLettersPoisonMessages(t *testing.T) {
+	dlq := &fakeWriter{}
+	ctx := context.Background()
+	invalid := kafka.Message{Topic: "driver-locations", Partition: 2, Offset: 41, Key: []byte("d1"), Value: []byte("{not json")}
+	process(ctx, invalid, &fakeUpdater{}, dlq)
+	failing := kafka.Message{Topic: "driver-locations", Partition: 2, Offset: 42, Key: []byte("d1"), Value: []byte(`{"id":"d1"}`)}
+	process(ctx, failing, &fakeUpdater{fail: redisAttempts}, dlq)
+	stale := kafka.Message{Topic: "driver-locations", Partition: 2, Offset: 43, Key: []byte("d1"), Value: []byte(`{"id":"d1","seq":1}`)}
+	process(ctx, stale, &fakeUpdater{stale: true}, dlq)
+
+	if len(dlq.msgs) != 2 {
+		t.Fatalf("expected the invalid and failing messages to be dead-lettered, got %d", len(dlq.msgs))
+	}
+	if got := header(dlq.msgs[0], headerOffset); got != "41" {
+		t.Fatalf("original offset = %q, want 41", got)
+	}
+	if got := header(dlq.msgs[1], headerAttempts); got != "3" {
+		t.Fatalf("attempts = %q, want 3", got)
+	}
+	if !strings.HasPrefix(header(dlq.msgs[1], headerError), "redis:") {
+		t.Fatalf("error header = %q", header(dlq.msgs[1], headerError))
+	}
+}
+
+func TestProcessDecodesLegacyAndEnvelopedMessages(t *testing.T) {
+	ctx := context.Background()
+	dlq := &fakeWriter{}
+	f := &fakeUpdater{}
+	enveloped, err := ingest.EncodeLocation(models.Driver{ID: "d2", Seq: 7}, ingest.EncodingProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process(ctx, kafka.Message{Key: []byte("d1"), Value: []byte(`{"id":"d1","seq":3}`)}, f, dlq)
+	process(ctx, enveloped, f, dlq)
+	if f.calls != 2 || len(dlq.msgs) != 0 {
+		t.Fatalf("expected both messages applied, got %d calls and %d dead-lettered", f.calls, len(dlq.msgs))
+	}
+
+	// a schema this consumer does not know yet is parked until it is upgraded
+	future := enveloped
+	future.Headers = []kafka.Header{
+		{Key: ingest.HeaderContentType, Value: []byte(ingest.ContentTypeProtobuf)},
+		{Key: ingest.HeaderSchemaVersion, Value: []byte("99")},
+	}
+	process(ctx, future, f, dlq)
+	if len(dlq.msgs) != 1 || header(dlq.msgs[0], ingest.HeaderSchemaVersion) != "99" {
+		t.Fatalf("expected the unknown version to be dead-lettered with its envelope, got %v", dlq.msgs)
+	}
+}
+
+func TestDeadLetterMessageKeepsOriginAcrossReplays(t *testing.T) {
+	orig := kafka.Message{Topic: "driver-locations", Partition: 1, Offset: 7, Key: []byte("d1"), Value: []byte("v"),
+		Headers: []kafka.Header{{Key: "trace-id", Value: []byte("abc")}}}
+	first := deadLetterMessage(orig, errors.New("boom"), 3, time.Now())
+	// the replay reads the message back from the DLQ topic
+	first.Topic, first.Partition, first.Offset = "driver-locations-dlq", 0, 99
+	second := deadLetterMessage(first, errors.New("boom again"), 3, time.Now())
+
+	for key, want := range map[string]string{
+		headerTopic:     "driver-locations",
+		headerPartition: "1",
+		headerOffset:    "7",
+		headerAttempts:  "6",
+		headerError:     "boom again",
+		"trace-id":      "abc",
+	} {
+		if got := header(second, key); got != want {
+			t.Fatalf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if n := len(second.Headers); n != 7 {
+		t.Fatalf("headers duplicated on replay: %v", second.Headers)
+	}
+}
+
+func TestProcessBatchKeepsPerDriverOrder(t *testing.T) {
+	var batch []kafka.Message
+	for seq := 1; seq <= 5; seq++ {
+		for _, id := range []string{"d1", "d2", "d3", "d4"} {
+			v := fmt.Sprintf(`{"id":%q,"seq":%d}`, id, seq)
+			batch = append(batch, kafka.Message{Partition: 0, Offset: int64(len(batch)), Key: []byte(id), Value: []byte(v)})
+		}
+	}
+	f := &fakeUpdater{}
+	const workers = 3
+	if n, err := processBatch(context.Background(), batch, f, &fakeWriter{}, workers); n != len(batch) || err != nil {
+		t.Fatalf("processed %d of %d messages: %v", n, len(batch), err)
+	}
+
+	if len(f.batches) == 0 || len(f.batches) > workers {
+		t.Fatalf("expected at most one pipeline per worker, got %d", len(f.batches))
+	}
+	last := map[string]uint64{}
+	total := 0
+	for _, b := range f.batches {
+		for _, d := range b {
+			if d.Seq <= last[d.ID] {
+				t.Fatalf("driver %s applied seq %d after %d", d.ID, d.Seq, last[d.ID])
+			}
+			last[d.ID] = d.Seq
+			total++
+		}
+	}
+	if total != len(batch) {
+		t.Fatalf("applied %d of %d pings", total, len(batch))
+	}
+}
+
+func TestUpdateRedisBatchWithRetryRetriesOnlyFailures(t *testing.T) {
+	f := &fakeUpdater{fail: 1}
+	ds := []*models.Driver{{ID: "d1"}, {ID: "d2"}}
+	errs := updateRedisBatchWithRetry(context.Background(), f, ds, 3, time.Millisecond)
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("expected both to succeed, got %v", errs)
+	}
+	if len(f.batches) != 2 || len(f.batches[1]) != 1 || f.batches[1][0].ID != "d1" {
+		t.Fatalf("expected only d1 to be retried, got %v", f.batches)
+	}
+}
+
+func TestProcessBatchStopsAtMessageThatCannotBeDeadLettered(t *testing.T) {
+	ctx := context.Background()
+	down := errors.New("dlq unavailable")
+	batch := []kafka.Message{
+		{Offset: 0, Key: []byte("d1"), Value: []byte(`{"id":"d1","seq":1}`)},
+		{Offset: 1, Key: []byte("d2"), Value: []byte(`{"id":"d2","seq":1}`)},
+		{Offset: 2, Key: []byte("d3"), Value: []byte("{not json")},
+		{Offset: 3, Key: []byte("d4"), Value: []byte(`{"id":"d4","seq":1}`)},
+	}
+	n, err := processBatch(ctx, batch, &fakeUpdater{}, &fakeWriter{err: down}, 2)
+	if n != 2 || !errors.Is(err, down) {
+		t.Fatalf("expected to commit the 2 messages before the invalid one, got %d: %v", n, err)
+	}
+
+	// a Redis failure that cannot be dead-lettered holds back the commit too
+	n, err = processBatch(ctx, batch[:2], &fakeUpdater{fail: 2 * redisAttempts}, &fakeWriter{err: down}, 1)
+	if n != 0 || !errors.Is(err, down) {
+		t.Fatalf("expected nothing to be committable, got %d: %v", n, err)
+	}
+	if err := process(ctx, batch[2], &fakeUpdater{}, &fakeWriter{err: down}); !errors.Is(err, down) {
+		t.Fatalf("process = %v, want the dead-letter error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to dead-lettered messages. The origin headers are set the
+// first time a message is dead-lettered and kept across replays.
+const (
+	headerError     = "x-dlq-error"
+	headerAttempts  = "x-dlq-attempts" // Redis attempts across all runs
+	headerFailedAt  = "x-dlq-failed-at"
+	headerTopic     = "x-dlq-original-topic"
+	headerPartition = "x-dlq-original-partition"
+	headerOffset    = "x-dlq-original-offset"
+)
+
+// replayIdleTimeout ends a replay once the DLQ has been quiet this long.
+const replayIdleTimeout = 10 * time.Second
+
+// messageWriter is the subset of kafka.Writer used to dead-letter messages.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// deadLetterMessage copies m for the dead-letter topic, recording why it
+// failed, how many Redis attempts it has had and where it was first read.
+func deadLetterMessage(m kafka.Message, cause error, attempts int, now time.Time) kafka.Message {
+	set := map[string]string{
+		headerError:     cause.Error(),
+		headerAttempts:  strconv.Itoa(attempts + headerInt(m, headerAttempts)),
+		headerFailedAt:  now.UTC().Format(time.RFC3339Nano),
+		headerTopic:     m.Topic,
+		headerPartition: strconv.Itoa(m.Partition),
+		headerOffset:    strconv.FormatInt(m.Offset, 10),
+	}
+	var headers []kafka.Header
+	for _, h := range m.Headers {
+		switch h.Key {
+		case headerTopic, headerPartition, headerOffset:
+			// a replayed message keeps its original position
+			set[h.Key] = string(h.Value)
+		case headerError, headerAttempts, headerFailedAt:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	for _, k := range []string{headerError, headerAttempts, headerFailedAt, headerTopic, headerPartition, headerOffset} {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(set[k])})
+	}
+	return kafka.Message{Key: m.Key, Value: m.Value, Headers: headers}
+}
+
+// deadLetter writes m to the dead-letter topic. It returns the write error
+// when the message could not be dead-lettered, in which case it must not
+// be committed.
+func deadLetter(ctx context.Context, dlq messageWriter, m kafka.Message, cause error, attempts int) error {
+	if err := dlq.WriteMessages(ctx, deadLetterMessage(m, cause, attempts, time.Now())); err != nil {
+		return fmt.Errorf("dead-letter partition=%d offset=%d: %w", m.Partition, m.Offset, err)
+	}
+	msgsDeadLettered.Inc()
+	return nil
+}
+
+func headerValue(m kafka.Message, key string) (string, bool) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+func headerInt(m kafka.Message, key string) int {
+	v, _ := headerValue(m, key)
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// replayDLQ re-drives dead-lettered messages through process until the
+// topic has been idle for replayIdleTimeout or it reaches a message
+// dead-lettered after the replay started, which includes messages that
+// failed again during this replay. It returns the number of messages
+// replayed; a message that can neither be applied nor dead-lettered again
+// stops the replay uncommitted.
+func replayDLQ(ctx context.Context, r *kafka.Reader, rc RedisUpdater, dlq messageWriter) (int, error) {
+	started := time.Now()
+	replayed := 0
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, replayIdleTimeout)
+		m, err := r.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+		if v, ok := headerValue(m, headerFailedAt); ok {
+			if at, err := time.Parse(time.RFC3339Nano, v); err == nil && !at.Before(started) {
+				return replayed, nil
+			}
+		}
+		if err := process(ctx, m, rc, dlq); err != nil {
+			return replayed, err
+		}
+		if err := r.CommitMessages(ctx, m); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
@@ -17,6 +17,9 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
 
+	"github.com/example/ride-matching/internal/config"
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/ingest"
 	"github.com/example/ride-matching/internal/models"
 )
 
@@ -37,48 +40,52 @@ var (
 		Name: "consumer_redis_errors_total",
 		Help: "Total redis errors",
 	})
+	msgsStale = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_messages_stale_total",
+		Help: "Total location messages dropped as duplicate or out of order",
+	})
+	msgsDeadLettered = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_messages_dead_lettered_total",
+		Help: "Total messages written to the dead-letter topic",
+	})
 )
 
 func init() {
-	prometheus.MustRegister(msgsConsumed, msgsInvalid, redisUpdates, redisErrors)
+	prometheus.MustRegister(msgsConsumed, msgsInvalid, redisUpdates, redisErrors, msgsStale, msgsDeadLettered)
 }
 
-func main() {
-	// allow some flags for local runs
-	var metricsAddr string
-	flag.StringVar(&metricsAddr, "metrics-addr", ":2112", "address to serve prometheus metrics on")
-	flag.Parse()
+// redisAttempts is how often a message's Redis update is tried before it
+// is dead-lettered, starting retryDelay apart and doubling.
+const (
+	redisAttempts = 3
+	retryDelay    = 200 * time.Millisecond
+)
 
-	brokersEnv := os.Getenv("KAFKA_BROKERS")
-	if brokersEnv == "" {
-		brokersEnv = os.Getenv("KAFKA_BROKER")
-	}
-	brokers := []string{}
-	if brokersEnv != "" {
-		for _, b := range strings.Split(brokersEnv, ",") {
-			if s := strings.TrimSpace(b); s != "" {
-				brokers = append(brokers, s)
-			}
-		}
-	} else {
-		brokers = []string{"localhost:9092"}
+func main() {
+	cfg, err := config.LoadConsumerConfig()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
 	}
 
-	topic := os.Getenv("KAFKA_TOPIC")
-	if topic == "" {
-		topic = "driver-locations"
+	// allow some flags for local runs
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "address to serve prometheus metrics on")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [replay-dlq]\n", os.Args[0])
+		flag.PrintDefaults()
 	}
-	group := os.Getenv("KAFKA_GROUP")
-	if group == "" {
-		group = "ride-matching-consumer"
+	flag.Parse()
+	replay := false
+	switch flag.Arg(0) {
+	case "":
+	case "replay-dlq":
+		replay = true
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
-	}
-	rc := redis.NewClient(&redis.Options{Addr: redisAddr})
-	radapter := &redisAdapter{c: rc}
+	rc := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr, Password: cfg.RedisPassword, DB: cfg.RedisDB})
+	writer := geo.NewDriverStateWriter(rc, cfg.RedisGeoKey)
 
 	// start metrics and health server
 	go func() {
@@ -94,8 +101,8 @@ func main() {
 			w.WriteHeader(200)
 			w.Write([]byte("ready"))
 		})
-		log.Printf("metrics/health listening on %s", metricsAddr)
-		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+		log.Printf("metrics/health listening on %s", cfg.MetricsAddr)
+		if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
 			log.Printf("metrics server stopped: %v", err)
 		}
 	}()
@@ -103,19 +110,44 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: topic, GroupID: group, MinBytes: 10e3, MaxBytes: 10e6})
+	dlq := &kafka.Writer{Addr: kafka.TCP(cfg.KafkaBrokers...), Topic: cfg.KafkaDLQTopic, Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll}
+	defer func() { _ = dlq.Close() }()
+
+	if replay {
+		// a separate group so the replay position is independent of any
+		// other DLQ readers
+		r := kafka.NewReader(kafka.ReaderConfig{Brokers: cfg.KafkaBrokers, Topic: cfg.KafkaDLQTopic, GroupID: cfg.KafkaGroup + "-dlq-replay", MinBytes: 1, MaxBytes: 10e6})
+		log.Printf("replaying dead-lettered messages topic=%s brokers=%v", cfg.KafkaDLQTopic, cfg.KafkaBrokers)
+		n, err := replayDLQ(ctx, r, writer, dlq)
+		_ = r.Close()
+		_ = rc.Close()
+		log.Printf("replayed %d messages", n)
+		if err != nil && ctx.Err() == nil {
+			log.Fatalf("replay stopped: %v", err)
+		}
+		return
+	}
+
+	newReader := func() *kafka.Reader {
+		return kafka.NewReader(kafka.ReaderConfig{Brokers: cfg.KafkaBrokers, Topic: cfg.KafkaTopic, GroupID: cfg.KafkaGroup, MinBytes: 10e3, MaxBytes: 10e6})
+	}
+	r := newReader()
 	defer func() {
 		_ = r.Close()
 		_ = rc.Close()
 	}()
 
-	log.Printf("consumer listening topic=%s brokers=%v group=%s", topic, brokers, group)
+	log.Printf("consumer listening topic=%s brokers=%v group=%s dlq=%s workers=%d batch=%d geo_key=%s",
+		cfg.KafkaTopic, cfg.KafkaBrokers, cfg.KafkaGroup, cfg.KafkaDLQTopic, cfg.Workers, cfg.BatchSize, cfg.RedisGeoKey)
 
+	// a batch that was fetched is finished and committed even if a shutdown
+	// signal arrives meanwhile
+	work := context.WithoutCancel(ctx)
 	backoff := time.Second
 	const maxBackoff = 30 * time.Second
 
 	for {
-		m, err := r.ReadMessage(ctx)
+		batch, err := fetchBatch(ctx, r, cfg.BatchSize)
 		if err != nil {
 			if ctx.Err() != nil {
 				log.Println("shutting down consumer")
@@ -129,50 +161,102 @@ func main() {
 			}
 			continue
 		}
-		// reset backoff on success
-		backoff = time.Second
-
-		msgsConsumed.Inc()
 
-		var d models.Driver
-		if err := json.Unmarshal(m.Value, &d); err != nil {
-			msgsInvalid.Inc()
-			log.Printf("invalid message: %v", err)
-			continue
+		n, err := processBatch(work, batch, writer, dlq, cfg.Workers)
+		// commit only the messages that were written or dead-lettered, so a
+		// crash or a dead-letter outage redelivers the rest instead of
+		// losing them
+		if n > 0 {
+			if err := r.CommitMessages(work, batch[:n]...); err != nil {
+				log.Printf("kafka commit failed: %v", err)
+			}
 		}
-
-		// Try updating Redis with retries and small backoff
-		if err := updateRedisWithRetry(ctx, radapter, &d, 3, 200*time.Millisecond); err != nil {
-			redisErrors.Inc()
-			log.Printf("redis update failed for driver=%s: %v", d.ID, err)
+		if err != nil {
+			m := batch[n]
+			log.Printf("message partition=%d offset=%d not processed: %v; backing off %s", m.Partition, m.Offset, err, backoff)
+			// the reader has already fetched past it; rejoin the group so
+			// reading resumes from the last commit
+			_ = r.Close()
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			r = newReader()
 			continue
 		}
-		redisUpdates.Inc()
+		// reset backoff on success
+		backoff = time.Second
 	}
 }
 
-// RedisUpdater defines the small subset of redis operations we need for tests and production.
-type RedisUpdater interface {
-	GeoAdd(ctx context.Context, key string, loc *redis.GeoLocation) error
-	HSet(ctx context.Context, key string, values map[string]interface{}) error
+// process applies one location message to Redis. Messages that cannot be
+// decoded, or whose update still fails after redisAttempts, go to the
+// dead-letter topic. It returns an error only when the message could not be
+// dead-lettered either.
+func process(ctx context.Context, m kafka.Message, rc RedisUpdater, dlq messageWriter) error {
+	d, err := decode(ctx, m, dlq)
+	if d == nil {
+		return err
+	}
+	// Try updating Redis with retries and small backoff
+	err = updateRedisWithRetry(ctx, rc, d, redisAttempts, retryDelay)
+	return record(ctx, m, d, err, dlq)
 }
 
-type redisAdapter struct{ c *redis.Client }
+// decode parses a location message, legacy JSON or enveloped (see
+// ingest.DecodeLocation). An invalid message is dead-lettered and decode
+// returns a nil driver, with the dead-letter error if that failed too.
+func decode(ctx context.Context, m kafka.Message, dlq messageWriter) (*models.Driver, error) {
+	msgsConsumed.Inc()
+	d, err := ingest.DecodeLocation(m)
+	if err != nil {
+		msgsInvalid.Inc()
+		log.Printf("invalid message: %v", err)
+		return nil, deadLetter(ctx, dlq, m, fmt.Errorf("decode: %w", err), 0)
+	}
+	return &d, nil
+}
 
-func (r *redisAdapter) GeoAdd(ctx context.Context, key string, loc *redis.GeoLocation) error {
-	_, err := r.c.GeoAdd(ctx, key, loc).Result()
-	return err
+// record counts the outcome of a message's Redis update and dead-letters
+// it if the update failed. It returns an error when the message was neither
+// applied nor dead-lettered.
+func record(ctx context.Context, m kafka.Message, d *models.Driver, err error, dlq messageWriter) error {
+	if errors.Is(err, errStaleLocation) {
+		msgsStale.Inc()
+		return nil
+	}
+	if err != nil {
+		redisErrors.Inc()
+		log.Printf("redis update failed for driver=%s: %v", d.ID, err)
+		return deadLetter(ctx, dlq, m, fmt.Errorf("redis: %w", err), redisAttempts)
+	}
+	redisUpdates.Inc()
+	return nil
 }
 
-func (r *redisAdapter) HSet(ctx context.Context, key string, values map[string]interface{}) error {
-	_, err := r.c.HSet(ctx, key, values).Result()
-	return err
+// errStaleLocation is returned when a ping is not newer than the last one
+// applied for the driver.
+var errStaleLocation = errors.New("location ping is not newer than the stored one")
+
+// RedisUpdater defines the small subset of redis operations we need for
+// tests and production; geo.DriverStateWriter implements it.
+type RedisUpdater interface {
+	// ApplyLocation writes the ping and reports false without writing
+	// anything when a newer or identical ping was already applied.
+	ApplyLocation(ctx context.Context, d *models.Driver, now time.Time) (bool, error)
+	// ApplyLocations applies the pings in order in one round trip and
+	// reports ApplyLocation's result for each of them.
+	ApplyLocations(ctx context.Context, ds []*models.Driver, now time.Time) ([]bool, []error)
 }
 
-// updateRedisWithRetry updates redis using the RedisUpdater interface with retry/backoff.
+// updateRedisWithRetry updates redis using the RedisUpdater interface with
+// retry/backoff. It returns errStaleLocation without retrying when the ping
+// was older than, or a duplicate of, the stored one.
 func updateRedisWithRetry(ctx context.Context, rc RedisUpdater, d *models.Driver, attempts int, delay time.Duration) error {
 	for i := 0; i < attempts; i++ {
-		if err := rc.GeoAdd(ctx, "drivers_geo", &redis.GeoLocation{Longitude: d.Loc.Lon, Latitude: d.Loc.Lat, Name: d.ID}); err != nil {
+		applied, err := rc.ApplyLocation(ctx, d, time.Now())
+		if err != nil {
 			if i == attempts-1 {
 				return err
 			}
@@ -180,13 +264,8 @@ func updateRedisWithRetry(ctx context.Context, rc RedisUpdater, d *models.Driver
 			delay *= 2
 			continue
 		}
-		if err := rc.HSet(ctx, "driver:meta:"+d.ID, map[string]interface{}{"rating": d.Rating, "online": d.Online}); err != nil {
-			if i == attempts-1 {
-				return err
-			}
-			time.Sleep(delay)
-			delay *= 2
-			continue
+		if !applied {
+			return errStaleLocation
 		}
 		return nil
 	}
@@ -10,7 +10,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -19,6 +19,8 @@ import (
 	"github.com/example/ride-matching/internal/config"
 	httpapi "github.com/example/ride-matching/internal/http"
 	"github.com/example/ride-matching/internal/logging"
+	"github.com/example/ride-matching/internal/migrate"
+	"github.com/example/ride-matching/migrations"
 )
 
 func main() {
@@ -29,6 +31,14 @@ func main() {
 
 	logger := logging.NewLogger(cfg.LogLevel)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:], cfg.PGDSN, logger); err != nil {
+			logger.Error("migrate failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if cfg.RunMigrations && cfg.PGDSN != "" {
 		if err := runMigrations(cfg.PGDSN, logger); err != nil {
 			logger.Error("migration failed", "error", err)
@@ -53,6 +63,8 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	srv.Start(ctx)
+
 	go func() {
 		logger.Info("ride-matching listening", "addr", cfg.HTTPAddr)
 		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -71,30 +83,97 @@ func main() {
 	} else {
 		logger.Info("server stopped cleanly")
 	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("flushing kafka producer failed", "error", err)
+	}
 }
 
-func runMigrations(dsn string, logger *slog.Logger) error {
-	logger.Info("running migrations")
-	db, err := sql.Open("postgres", dsn)
+// runMigrate implements "ride-matching migrate up|down N|status" so
+// migrations can run as a separate Job before the server rolls out.
+func runMigrate(args []string, dsn string, logger *slog.Logger) error {
+	if dsn == "" {
+		return errors.New("PG_DSN is required")
+	}
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down N | status")
+	}
+	runner, closeDB, err := newMigrationRunner(dsn, logger)
 	if err != nil {
-		return fmt.Errorf("open db: %w", err)
+		return err
 	}
-	defer db.Close()
+	defer closeDB()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
-		return fmt.Errorf("ping db: %w", err)
+	switch args[0] {
+	case "up":
+		n, err := runner.Up(ctx)
+		logger.Info("migrations up", "applied", n)
+		return err
+	case "down":
+		if len(args) != 2 {
+			return errors.New("usage: migrate down N")
+		}
+		steps, err := strconv.Atoi(args[1])
+		if err != nil || steps <= 0 {
+			return fmt.Errorf("invalid step count %q", args[1])
+		}
+		n, err := runner.Down(ctx, steps)
+		logger.Info("migrations down", "reverted", n)
+		return err
+	case "status":
+		states, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			status := "pending"
+			switch {
+			case st.Modified:
+				status = "modified"
+			case st.Applied:
+				status = "applied " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%03d_%s\t%s\n", st.Version, st.Name, status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
 	}
+}
 
-	query, err := os.ReadFile(filepath.Join("migrations", "001_create_rides.sql"))
+func runMigrations(dsn string, logger *slog.Logger) error {
+	logger.Info("running migrations")
+	runner, closeDB, err := newMigrationRunner(dsn, logger)
 	if err != nil {
-		return fmt.Errorf("read migration: %w", err)
+		return err
 	}
+	defer closeDB()
 
-	if _, err := db.ExecContext(ctx, string(query)); err != nil {
-		return fmt.Errorf("exec migration: %w", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	n, err := runner.Up(ctx)
+	if err != nil {
+		return err
 	}
-	logger.Info("migration applied", "file", "001_create_rides.sql")
+	logger.Info("migrations complete", "applied", n)
 	return nil
 }
+
+func newMigrationRunner(dsn string, logger *slog.Logger) (*migrate.Runner, func(), error) {
+	migs, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load migrations: %w", err)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open db: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("ping db: %w", err)
+	}
+	return &migrate.Runner{DB: db, Migrations: migs, Logger: logger}, func() { db.Close() }, nil
+}
@@ -0,0 +1,78 @@
+// Command telemetry receives signed driver location frames over UDP and
+// forwards them into the location pipeline; see ingest.TelemetryReceiver.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/example/ride-matching/internal/config"
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/ingest"
+)
+
+func main() {
+	cfg, err := config.LoadTelemetryConfig()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	flag.StringVar(&cfg.Addr, "addr", cfg.Addr, "UDP address to receive telemetry frames on")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "address to serve prometheus metrics on")
+	flag.Parse()
+
+	pc, err := ingest.NewProducerConfig(cfg.KafkaBrokers, cfg.KafkaTopic, ingest.Encoding(cfg.KafkaEncoding), cfg.KafkaProducer)
+	if err != nil {
+		log.Fatalf("kafka producer: %v", err)
+	}
+	producer := ingest.NewKafkaProducer(pc)
+
+	recv := &ingest.TelemetryReceiver{
+		Secret:    []byte(cfg.Secret),
+		Publisher: producer,
+		Rate:      cfg.Rate,
+		Burst:     cfg.Burst,
+		Workers:   cfg.Workers,
+		Logger:    slog.Default(),
+	}
+	if cfg.RedisAddr != "" {
+		recv.Geo = geo.NewRedisGeo(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.RedisGeoKey)
+	}
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("ok")) })
+		log.Printf("metrics/health listening on %s", cfg.MetricsAddr)
+		if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+
+	conn, err := net.ListenPacket("udp", cfg.Addr)
+	if err != nil {
+		log.Fatalf("listen %s: %v", cfg.Addr, err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("telemetry listening udp=%s topic=%s brokers=%v redis=%q rate=%g burst=%d",
+		cfg.Addr, cfg.KafkaTopic, cfg.KafkaBrokers, cfg.RedisAddr, cfg.Rate, cfg.Burst)
+	if err := recv.Serve(ctx, conn); err != nil {
+		log.Printf("telemetry receiver stopped: %v", err)
+	}
+	// flush pings still queued for Kafka
+	if err := producer.Close(); err != nil {
+		log.Printf("kafka producer close: %v", err)
+	}
+	log.Println("telemetry stopped")
+}
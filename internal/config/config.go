@@ -21,15 +21,58 @@ type ServerConfig struct {
 
 	RedisAddr     string
 	RedisPassword string
+	RedisDB       int
 	RedisGeoKey   string
+	// DriverMaxAge hides drivers whose last location is older than this and
+	// lets the sweeper evict them every DriverSweepInterval.
+	DriverMaxAge        time.Duration
+	DriverSweepInterval time.Duration
+	// OnlineGaugeInterval is how often the per-city online driver gauge is
+	// recomputed from the geo store.
+	OnlineGaugeInterval time.Duration
 
-	KafkaBrokers []string
-	KafkaTopic   string
+	KafkaBrokers         []string
+	KafkaTopic           string
+	KafkaRideEventsTopic string
+	// KafkaEncoding is the payload format of produced messages: "json",
+	// the default while consumers are migrated, or "protobuf" once every
+	// consumer decodes it.
+	KafkaEncoding      string
+	KafkaProducer      KafkaProducerConfig
+	OutboxPollInterval time.Duration
+	// IngestMaxBatch caps the pings accepted by one batch location request.
+	IngestMaxBatch int
 
 	PGDSN string
 
-	DefaultSpeedMps float64
-	MatcherTopN     int
+	DefaultSpeedMps     float64
+	MatcherTopN         int
+	MatcherOfferTimeout time.Duration
+	MatcherTripHold     time.Duration
+	// MatcherHeadingPenalty is the cost added to a moving driver heading
+	// directly away from the pickup; 0 disables the term.
+	MatcherHeadingPenalty time.Duration
+	MatcherMode           string // "greedy" or "batch"
+	MatcherBatchWindow    time.Duration
+	// candidate search: start at SearchRadius meters and widen by
+	// SearchStep up to SearchMaxRadius until MinCandidates drivers are found
+	SearchRadius    float64
+	SearchMaxRadius float64
+	SearchStep      float64
+	MinCandidates   int
+
+	SurgeInterval      time.Duration
+	SurgeCellPrecision int
+	SurgeMaxMultiplier float64
+	SurgeSensitivity   float64
+	SurgeSmoothing     float64
+
+	OSRMURL         string
+	PricingFares    string // JSON city -> fare model; empty uses pricing.DefaultFares
+	QuoteSigningKey string
+	QuoteTTL        time.Duration
+
+	StripeAPIKey string // empty uses the in-memory fake payment provider
 
 	LogLevel      string
 	RunMigrations bool
@@ -37,16 +80,39 @@ type ServerConfig struct {
 
 func defaultServerConfig() ServerConfig {
 	return ServerConfig{
-		HTTPAddr:        ":8080",
-		ReadTimeout:     5 * time.Second,
-		WriteTimeout:    10 * time.Second,
-		IdleTimeout:     120 * time.Second,
-		ShutdownTimeout: 15 * time.Second,
-		RedisGeoKey:     "drivers_geo",
-		KafkaTopic:      "driver-locations",
-		DefaultSpeedMps: 10,
-		MatcherTopN:     8,
-		LogLevel:        "info",
+		HTTPAddr:              ":8080",
+		ReadTimeout:           5 * time.Second,
+		WriteTimeout:          10 * time.Second,
+		IdleTimeout:           120 * time.Second,
+		ShutdownTimeout:       15 * time.Second,
+		RedisGeoKey:           "drivers_geo",
+		DriverMaxAge:          2 * time.Minute,
+		DriverSweepInterval:   30 * time.Second,
+		OnlineGaugeInterval:   15 * time.Second,
+		KafkaTopic:            "driver-locations",
+		KafkaRideEventsTopic:  "ride-events",
+		KafkaEncoding:         "json",
+		KafkaProducer:         defaultKafkaProducerConfig(),
+		OutboxPollInterval:    time.Second,
+		IngestMaxBatch:        500,
+		DefaultSpeedMps:       10,
+		MatcherTopN:           8,
+		MatcherOfferTimeout:   15 * time.Second,
+		MatcherTripHold:       4 * time.Hour,
+		MatcherHeadingPenalty: 2 * time.Minute,
+		MatcherMode:           "greedy",
+		MatcherBatchWindow:    2 * time.Second,
+		SearchRadius:          2000,
+		SearchMaxRadius:       10000,
+		SearchStep:            2000,
+		MinCandidates:         3,
+		SurgeInterval:         10 * time.Second,
+		SurgeCellPrecision:    6,
+		SurgeMaxMultiplier:    3,
+		SurgeSensitivity:      0.5,
+		SurgeSmoothing:        0.3,
+		QuoteTTL:              2 * time.Minute,
+		LogLevel:              "info",
 	}
 }
 
@@ -62,17 +128,49 @@ func LoadServerConfig() (ServerConfig, error) {
 
 	cfg.RedisAddr = strings.TrimSpace(os.Getenv("REDIS_ADDR"))
 	cfg.RedisPassword = os.Getenv("REDIS_PASSWORD")
+	setIntFromEnv(&cfg.RedisDB, "REDIS_DB", &errs)
 	setStringFromEnv(&cfg.RedisGeoKey, "REDIS_GEO_KEY")
+	setDurationFromEnv(&cfg.DriverMaxAge, "DRIVER_MAX_AGE", &errs)
+	setDurationFromEnv(&cfg.DriverSweepInterval, "DRIVER_SWEEP_INTERVAL", &errs)
+	setDurationFromEnv(&cfg.OnlineGaugeInterval, "DRIVERS_ONLINE_INTERVAL", &errs)
 
 	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
 		cfg.KafkaBrokers = splitAndTrim(brokers)
 	}
 	setStringFromEnv(&cfg.KafkaTopic, "KAFKA_TOPIC")
+	setStringFromEnv(&cfg.KafkaRideEventsTopic, "KAFKA_RIDE_EVENTS_TOPIC")
+	setLowerFromEnv(&cfg.KafkaEncoding, "KAFKA_MESSAGE_ENCODING")
+	cfg.KafkaProducer.load(&errs)
+	setDurationFromEnv(&cfg.OutboxPollInterval, "OUTBOX_POLL_INTERVAL", &errs)
+	setIntFromEnv(&cfg.IngestMaxBatch, "INGEST_MAX_BATCH", &errs)
 
 	cfg.PGDSN = os.Getenv("PG_DSN")
 
 	setFloatFromEnv(&cfg.DefaultSpeedMps, "MATCHER_DEFAULT_SPEED_MPS", &errs)
 	setIntFromEnv(&cfg.MatcherTopN, "MATCHER_TOP_N", &errs)
+	setDurationFromEnv(&cfg.MatcherOfferTimeout, "MATCHER_OFFER_TIMEOUT", &errs)
+	setDurationFromEnv(&cfg.MatcherTripHold, "MATCHER_TRIP_HOLD", &errs)
+	setDurationFromEnv(&cfg.MatcherHeadingPenalty, "MATCHER_HEADING_PENALTY", &errs)
+	if v := os.Getenv("MATCHER_MODE"); v != "" {
+		cfg.MatcherMode = strings.ToLower(strings.TrimSpace(v))
+	}
+	setDurationFromEnv(&cfg.MatcherBatchWindow, "MATCHER_BATCH_WINDOW", &errs)
+	setFloatFromEnv(&cfg.SearchRadius, "MATCHER_SEARCH_RADIUS_M", &errs)
+	setFloatFromEnv(&cfg.SearchMaxRadius, "MATCHER_SEARCH_MAX_RADIUS_M", &errs)
+	setFloatFromEnv(&cfg.SearchStep, "MATCHER_SEARCH_STEP_M", &errs)
+	setIntFromEnv(&cfg.MinCandidates, "MATCHER_MIN_CANDIDATES", &errs)
+
+	setDurationFromEnv(&cfg.SurgeInterval, "SURGE_INTERVAL", &errs)
+	setIntFromEnv(&cfg.SurgeCellPrecision, "SURGE_CELL_PRECISION", &errs)
+	setFloatFromEnv(&cfg.SurgeMaxMultiplier, "SURGE_MAX_MULTIPLIER", &errs)
+	setFloatFromEnv(&cfg.SurgeSensitivity, "SURGE_SENSITIVITY", &errs)
+	setFloatFromEnv(&cfg.SurgeSmoothing, "SURGE_SMOOTHING", &errs)
+
+	setStringFromEnv(&cfg.OSRMURL, "OSRM_URL")
+	setStringFromEnv(&cfg.PricingFares, "PRICING_FARES")
+	cfg.QuoteSigningKey = os.Getenv("QUOTE_SIGNING_KEY")
+	setDurationFromEnv(&cfg.QuoteTTL, "QUOTE_TTL", &errs)
+	cfg.StripeAPIKey = os.Getenv("STRIPE_API_KEY")
 
 	if v := os.Getenv("LOG_LEVEL"); v != "" {
 		cfg.LogLevel = strings.ToLower(v)
@@ -80,9 +178,290 @@ func LoadServerConfig() (ServerConfig, error) {
 
 	cfg.RunMigrations = strings.EqualFold(os.Getenv("MIGRATE"), "true")
 
+	if cfg.RedisDB < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_DB must be >= 0"))
+	}
+	if cfg.KafkaEncoding != "protobuf" && cfg.KafkaEncoding != "json" {
+		errs = append(errs, fmt.Errorf("KAFKA_MESSAGE_ENCODING must be protobuf or json, got %q", cfg.KafkaEncoding))
+	}
+	if cfg.IngestMaxBatch <= 0 {
+		errs = append(errs, fmt.Errorf("INGEST_MAX_BATCH must be > 0"))
+	}
 	if cfg.MatcherTopN <= 0 {
 		errs = append(errs, fmt.Errorf("MATCHER_TOP_N must be > 0"))
 	}
+	if cfg.MatcherOfferTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("MATCHER_OFFER_TIMEOUT must be > 0"))
+	}
+	if cfg.MatcherHeadingPenalty < 0 {
+		errs = append(errs, fmt.Errorf("MATCHER_HEADING_PENALTY must be >= 0"))
+	}
+	if cfg.DriverMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("DRIVER_MAX_AGE must be >= 0"))
+	}
+	if cfg.DriverSweepInterval <= 0 {
+		errs = append(errs, fmt.Errorf("DRIVER_SWEEP_INTERVAL must be > 0"))
+	}
+	if cfg.OnlineGaugeInterval <= 0 {
+		errs = append(errs, fmt.Errorf("DRIVERS_ONLINE_INTERVAL must be > 0"))
+	}
+	if cfg.SearchRadius <= 0 || cfg.SearchStep <= 0 {
+		errs = append(errs, fmt.Errorf("MATCHER_SEARCH_RADIUS_M and MATCHER_SEARCH_STEP_M must be > 0"))
+	}
+	if cfg.SearchMaxRadius < cfg.SearchRadius {
+		errs = append(errs, fmt.Errorf("MATCHER_SEARCH_MAX_RADIUS_M must be >= MATCHER_SEARCH_RADIUS_M"))
+	}
+	if cfg.SurgeInterval <= 0 {
+		errs = append(errs, fmt.Errorf("SURGE_INTERVAL must be > 0"))
+	}
+	if cfg.SurgeCellPrecision < 1 || cfg.SurgeCellPrecision > 12 {
+		errs = append(errs, fmt.Errorf("SURGE_CELL_PRECISION must be between 1 and 12"))
+	}
+	if cfg.SurgeMaxMultiplier < 1 {
+		errs = append(errs, fmt.Errorf("SURGE_MAX_MULTIPLIER must be >= 1"))
+	}
+	if cfg.SurgeSmoothing <= 0 || cfg.SurgeSmoothing > 1 {
+		errs = append(errs, fmt.Errorf("SURGE_SMOOTHING must be in (0, 1]"))
+	}
+	if cfg.QuoteTTL <= 0 {
+		errs = append(errs, fmt.Errorf("QUOTE_TTL must be > 0"))
+	}
+	switch cfg.MatcherMode {
+	case "greedy":
+	case "batch":
+		if cfg.MatcherBatchWindow <= 0 {
+			errs = append(errs, fmt.Errorf("MATCHER_BATCH_WINDOW must be > 0 in batch mode"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MATCHER_MODE must be greedy or batch, got %q", cfg.MatcherMode))
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+// KafkaProducerConfig configures the location producer of the server and
+// the telemetry receiver. In "async" Mode it queues up to QueueSize
+// messages and either drops or blocks on new ones when full (QueueFull);
+// "sync" waits for every write. Batches are sent at BatchSize messages or
+// after Linger.
+type KafkaProducerConfig struct {
+	Mode        string
+	QueueSize   int
+	QueueFull   string // "drop" or "block"
+	BatchSize   int
+	Linger      time.Duration
+	Acks        string // "none", "one" or "all"
+	Compression string // "none", "gzip", "snappy", "lz4" or "zstd"
+}
+
+func defaultKafkaProducerConfig() KafkaProducerConfig {
+	return KafkaProducerConfig{
+		Mode:        "async",
+		QueueSize:   10000,
+		QueueFull:   "drop",
+		BatchSize:   100,
+		Linger:      10 * time.Millisecond,
+		Acks:        "all",
+		Compression: "none",
+	}
+}
+
+// load reads the KAFKA_PRODUCER_* variables and validates the result.
+func (c *KafkaProducerConfig) load(errs *[]error) {
+	setLowerFromEnv(&c.Mode, "KAFKA_PRODUCER_MODE")
+	setIntFromEnv(&c.QueueSize, "KAFKA_PRODUCER_QUEUE_SIZE", errs)
+	setLowerFromEnv(&c.QueueFull, "KAFKA_PRODUCER_QUEUE_FULL")
+	setIntFromEnv(&c.BatchSize, "KAFKA_PRODUCER_BATCH_SIZE", errs)
+	setDurationFromEnv(&c.Linger, "KAFKA_PRODUCER_LINGER", errs)
+	setLowerFromEnv(&c.Acks, "KAFKA_PRODUCER_ACKS")
+	setLowerFromEnv(&c.Compression, "KAFKA_PRODUCER_COMPRESSION")
+
+	if c.Mode != "async" && c.Mode != "sync" {
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_MODE must be async or sync, got %q", c.Mode))
+	}
+	if c.QueueSize <= 0 {
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_QUEUE_SIZE must be > 0"))
+	}
+	if c.QueueFull != "drop" && c.QueueFull != "block" {
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_QUEUE_FULL must be drop or block, got %q", c.QueueFull))
+	}
+	if c.BatchSize <= 0 {
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_BATCH_SIZE must be > 0"))
+	}
+	if c.Linger <= 0 {
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_LINGER must be > 0"))
+	}
+	switch c.Acks {
+	case "none", "one", "all":
+	default:
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_ACKS must be none, one or all, got %q", c.Acks))
+	}
+	switch c.Compression {
+	case "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		*errs = append(*errs, fmt.Errorf("KAFKA_PRODUCER_COMPRESSION must be none, gzip, snappy, lz4 or zstd, got %q", c.Compression))
+	}
+}
+
+// ConsumerConfig holds the settings of the location consumer (cmd/consumer).
+// It shares the Redis and Kafka variables with ServerConfig so both
+// processes address the same keys and topics.
+type ConsumerConfig struct {
+	MetricsAddr string
+
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisGeoKey   string
+
+	KafkaBrokers  []string
+	KafkaTopic    string
+	KafkaGroup    string
+	KafkaDLQTopic string // defaults to KafkaTopic + "-dlq"
+
+	Workers   int // concurrent Redis pipelines per batch
+	BatchSize int // messages fetched before writing and committing
+}
+
+func defaultConsumerConfig() ConsumerConfig {
+	return ConsumerConfig{
+		MetricsAddr:  ":2112",
+		RedisAddr:    "localhost:6379",
+		RedisGeoKey:  "drivers_geo",
+		KafkaBrokers: []string{"localhost:9092"},
+		KafkaTopic:   "driver-locations",
+		KafkaGroup:   "ride-matching-consumer",
+		Workers:      8,
+		BatchSize:    100,
+	}
+}
+
+func LoadConsumerConfig() (ConsumerConfig, error) {
+	cfg := defaultConsumerConfig()
+	var errs []error
+
+	setStringFromEnv(&cfg.MetricsAddr, "CONSUMER_METRICS_ADDR")
+
+	setStringFromEnv(&cfg.RedisAddr, "REDIS_ADDR")
+	cfg.RedisPassword = os.Getenv("REDIS_PASSWORD")
+	setIntFromEnv(&cfg.RedisDB, "REDIS_DB", &errs)
+	setStringFromEnv(&cfg.RedisGeoKey, "REDIS_GEO_KEY")
+
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		brokers = os.Getenv("KAFKA_BROKER")
+	}
+	if brokers != "" {
+		cfg.KafkaBrokers = splitAndTrim(brokers)
+	}
+	setStringFromEnv(&cfg.KafkaTopic, "KAFKA_TOPIC")
+	setStringFromEnv(&cfg.KafkaGroup, "KAFKA_GROUP")
+	cfg.KafkaDLQTopic = cfg.KafkaTopic + "-dlq"
+	setStringFromEnv(&cfg.KafkaDLQTopic, "KAFKA_DLQ_TOPIC")
+
+	setIntFromEnv(&cfg.Workers, "CONSUMER_WORKERS", &errs)
+	setIntFromEnv(&cfg.BatchSize, "CONSUMER_BATCH_SIZE", &errs)
+
+	if cfg.RedisDB < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_DB must be >= 0"))
+	}
+	if len(cfg.KafkaBrokers) == 0 {
+		errs = append(errs, fmt.Errorf("KAFKA_BROKERS must list at least one broker"))
+	}
+	if cfg.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_WORKERS must be > 0"))
+	}
+	if cfg.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_BATCH_SIZE must be > 0"))
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+// TelemetryConfig configures the UDP telemetry receiver (cmd/telemetry).
+// It shares the Redis and Kafka variables with ServerConfig.
+type TelemetryConfig struct {
+	Addr        string // UDP listen address
+	MetricsAddr string
+	// Secret derives the per-driver keys that sign telemetry frames.
+	Secret string
+	// Rate and Burst limit the pings accepted per driver and second.
+	Rate    float64
+	Burst   int
+	Workers int
+
+	// RedisAddr is optional; when set, pings are also written straight to
+	// the geo store like the server does.
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisGeoKey   string
+
+	KafkaBrokers  []string
+	KafkaTopic    string
+	KafkaEncoding string
+	KafkaProducer KafkaProducerConfig
+}
+
+func defaultTelemetryConfig() TelemetryConfig {
+	return TelemetryConfig{
+		Addr:          ":7070",
+		MetricsAddr:   ":2113",
+		Rate:          1,
+		Burst:         5,
+		Workers:       4,
+		RedisGeoKey:   "drivers_geo",
+		KafkaBrokers:  []string{"localhost:9092"},
+		KafkaTopic:    "driver-locations",
+		KafkaEncoding: "json",
+		KafkaProducer: defaultKafkaProducerConfig(),
+	}
+}
+
+func LoadTelemetryConfig() (TelemetryConfig, error) {
+	cfg := defaultTelemetryConfig()
+	var errs []error
+
+	setStringFromEnv(&cfg.Addr, "TELEMETRY_ADDR")
+	setStringFromEnv(&cfg.MetricsAddr, "TELEMETRY_METRICS_ADDR")
+	cfg.Secret = os.Getenv("TELEMETRY_SECRET")
+	setFloatFromEnv(&cfg.Rate, "TELEMETRY_RATE", &errs)
+	setIntFromEnv(&cfg.Burst, "TELEMETRY_BURST", &errs)
+	setIntFromEnv(&cfg.Workers, "TELEMETRY_WORKERS", &errs)
+
+	cfg.RedisAddr = strings.TrimSpace(os.Getenv("REDIS_ADDR"))
+	cfg.RedisPassword = os.Getenv("REDIS_PASSWORD")
+	setIntFromEnv(&cfg.RedisDB, "REDIS_DB", &errs)
+	setStringFromEnv(&cfg.RedisGeoKey, "REDIS_GEO_KEY")
+
+	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+		cfg.KafkaBrokers = splitAndTrim(brokers)
+	}
+	setStringFromEnv(&cfg.KafkaTopic, "KAFKA_TOPIC")
+	setLowerFromEnv(&cfg.KafkaEncoding, "KAFKA_MESSAGE_ENCODING")
+	cfg.KafkaProducer.load(&errs)
+
+	if cfg.Secret == "" {
+		errs = append(errs, fmt.Errorf("TELEMETRY_SECRET is required"))
+	}
+	if cfg.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("TELEMETRY_RATE must be > 0"))
+	}
+	if cfg.Burst <= 0 {
+		errs = append(errs, fmt.Errorf("TELEMETRY_BURST must be > 0"))
+	}
+	if cfg.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("TELEMETRY_WORKERS must be > 0"))
+	}
+	if cfg.RedisDB < 0 {
+		errs = append(errs, fmt.Errorf("REDIS_DB must be >= 0"))
+	}
+	if len(cfg.KafkaBrokers) == 0 {
+		errs = append(errs, fmt.Errorf("KAFKA_BROKERS must list at least one broker"))
+	}
+	if cfg.KafkaEncoding != "protobuf" && cfg.KafkaEncoding != "json" {
+		errs = append(errs, fmt.Errorf("KAFKA_MESSAGE_ENCODING must be protobuf or json, got %q", cfg.KafkaEncoding))
+	}
 
 	return cfg, errors.Join(errs...)
 }
@@ -120,6 +499,13 @@ func setIntFromEnv(target *int, key string, errs *[]error) {
 	}
 }
 
+// setLowerFromEnv reads an enum-like value, ignoring case and spaces.
+func setLowerFromEnv(target *string, key string) {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		*target = strings.ToLower(v)
+	}
+}
+
 func setStringFromEnv(target *string, key string) {
 	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
 		*target = v
@@ -0,0 +1,159 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+type received struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func (r *received) handle(ctx context.Context, driverID string, m Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, m)
+	if m.Type == MsgDecline {
+		return errors.New("offer expired")
+	}
+	return nil
+}
+
+// serve starts a server running reg's sessions at /ws/<driver id>.
+func serve(t *testing.T, reg *WSRegistry, handle MessageHandler) string {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		reg.Serve(r.Context(), strings.TrimPrefix(r.URL.Path, "/ws/"), conn, handle)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) Message {
+	var m Message
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWSSessionProtocol(t *testing.T) {
+	reg := NewWSRegistry()
+	got := &received{}
+	conn := dial(t, serve(t, reg, got.handle)+"d1")
+	waitFor(t, "session", func() bool { _, ok := reg.Session("d1"); return ok })
+
+	if err := reg.Offer("r1", models.MatchOffer{RideID: "r1", DriverID: "d1", ETA: 120}); err != nil {
+		t.Fatal(err)
+	}
+	if m := read(t, conn); m.Type != MsgOffer || m.RideID != "r1" || m.Offer == nil || m.Offer.ETA != 120 {
+		t.Fatalf("unexpected offer frame %+v", m)
+	}
+
+	for _, m := range []Message{{Type: MsgOfferAck, RideID: "r1"}, {Type: MsgAccept, RideID: "r1"}} {
+		if err := conn.WriteJSON(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.WriteJSON(Message{Type: MsgHeartbeat})
+	if m := read(t, conn); m.Type != MsgHeartbeat {
+		t.Fatalf("expected a heartbeat reply, got %+v", m)
+	}
+	conn.WriteJSON(Message{Type: MsgDecline, RideID: "r2"})
+	if m := read(t, conn); m.Type != MsgError || m.RideID != "r2" || m.Error != "offer expired" {
+		t.Fatalf("expected the handler error back, got %+v", m)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("{not json"))
+	if m := read(t, conn); m.Type != MsgError {
+		t.Fatalf("expected an error frame for invalid JSON, got %+v", m)
+	}
+	conn.WriteJSON(Message{Type: "dance"})
+	if m := read(t, conn); m.Type != MsgError {
+		t.Fatalf("expected an error frame for an unknown type, got %+v", m)
+	}
+
+	got.mu.Lock()
+	types := []MessageType{}
+	for _, m := range got.msgs {
+		types = append(types, m.Type)
+	}
+	got.mu.Unlock()
+	if len(types) != 3 || types[0] != MsgOfferAck || types[1] != MsgAccept || types[2] != MsgDecline {
+		t.Fatalf("handler saw %v", types)
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	waitFor(t, "session removal", func() bool { _, ok := reg.Session("d1"); return !ok })
+	if err := reg.Offer("r3", models.MatchOffer{DriverID: "d1"}); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("expected ErrNoSession after the driver left, got %v", err)
+	}
+}
+
+func TestWSReconnectClosesOldSession(t *testing.T) {
+	reg := NewWSRegistry()
+	url := serve(t, reg, (&received{}).handle) + "d1"
+	old := dial(t, url)
+	waitFor(t, "first session", func() bool { _, ok := reg.Session("d1"); return ok })
+	first, _ := reg.Session("d1")
+
+	fresh := dial(t, url)
+	waitFor(t, "replacement", func() bool { s, _ := reg.Session("d1"); return s != first })
+
+	_ = old.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := old.ReadMessage()
+	if !websocket.IsCloseError(err, CloseReplaced) {
+		t.Fatalf("expected the old connection to be closed with %d, got %v", CloseReplaced, err)
+	}
+	// the old session ending must not evict the new one
+	time.Sleep(20 * time.Millisecond)
+	if err := reg.Offer("r1", models.MatchOffer{DriverID: "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := read(t, fresh); m.Type != MsgOffer {
+		t.Fatalf("expected the offer on the new connection, got %+v", m)
+	}
+}
+
+func TestWSDropsSilentDriver(t *testing.T) {
+	reg := NewWSRegistry()
+	reg.PingPeriod, reg.PongWait = 10*time.Millisecond, 50*time.Millisecond
+	conn := dial(t, serve(t, reg, (&received{}).handle)+"d1")
+	// the client never reads, so it never answers pings
+	conn.SetPingHandler(func(string) error { return nil })
+	waitFor(t, "session", func() bool { _, ok := reg.Session("d1"); return ok })
+	waitFor(t, "silent session to be dropped", func() bool { _, ok := reg.Session("d1"); return !ok })
+}
@@ -26,7 +26,7 @@ func (p *PushDispatcher) Offer(rideID string, offer interface{}) error {
 			// try to convert to known MatchOffer shape
 			if eta, ok := m["eta"].(float64); ok {
 				if cost, ok := m["cost"].(float64); ok {
-					_ = p.WS.Offer(driverID, models.MatchOffer{DriverID: driverID, ETA: eta, Cost: cost})
+					_ = p.WS.Offer(rideID, models.MatchOffer{RideID: rideID, DriverID: driverID, ETA: eta, Cost: cost})
 					return nil
 				}
 			}
@@ -1,47 +1,223 @@
 package dispatch
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/example/ride-matching/internal/models"
 	"github.com/gorilla/websocket"
 )
 
-// WSSession represents a connected driver session
+// MessageType names a frame of the driver WebSocket protocol.
+type MessageType string
+
+const (
+	MsgOffer     MessageType = "offer"     // server → driver: Offer is a new ride offer
+	MsgOfferAck  MessageType = "offer_ack" // driver → server: the offer for RideID was shown
+	MsgAccept    MessageType = "accept"    // driver → server: take the ride RideID
+	MsgDecline   MessageType = "decline"   // driver → server: pass on the ride RideID
+	MsgLocation  MessageType = "location"  // driver → server: Location is a location ping
+	MsgHeartbeat MessageType = "heartbeat" // either way; a heartbeat from the driver is answered with one
+	MsgError     MessageType = "error"     // server → driver: a frame was rejected, see Error
+)
+
+// Message is every frame of the driver WebSocket protocol, a JSON object
+// whose fields depend on Type.
+type Message struct {
+	Type     MessageType        `json:"type"`
+	RideID   string             `json:"ride_id,omitempty"`
+	Offer    *models.MatchOffer `json:"offer,omitempty"`
+	Location *models.Driver     `json:"location,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// MessageHandler applies a frame a driver sent. A returned error goes back
+// to the driver as an error frame.
+type MessageHandler func(ctx context.Context, driverID string, m Message) error
+
+// Close codes sent to drivers besides the standard ones.
+const (
+	CloseReplaced = 4000 // the driver connected again; this connection is stale
+)
+
+var (
+	ErrSessionClosed = errors.New("ws session closed")
+	ErrSessionBusy   = errors.New("ws session send buffer full")
+)
+
+const (
+	maxMessageSize = 8 << 10
+	sendBuffer     = 16
+)
+
+// WSSession is a connected driver. Its write pump owns all writes to the
+// connection; Send only queues.
 type WSSession struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	DriverID string
+
+	conn      *websocket.Conn
+	reg       *WSRegistry
+	send      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func (s *WSSession) Send(offer models.MatchOffer) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.conn.WriteJSON(offer)
+// Send queues m for the driver. It fails instead of blocking when the
+// driver is not keeping up.
+func (s *WSSession) Send(m Message) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+	select {
+	case s.send <- m:
+		return nil
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+		return ErrSessionBusy
+	}
+}
+
+// Close sends a close frame with code and reason and closes the
+// connection. Only the first call has an effect.
+func (s *WSSession) Close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		deadline := time.Now().Add(s.reg.WriteWait)
+		_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		_ = s.conn.Close()
+	})
 }
 
-// WSRegistry holds driver sessions
+// readPump handles the driver's frames until the connection fails, is
+// closed or stays silent for PongWait.
+func (s *WSSession) readPump(ctx context.Context, handle MessageHandler) {
+	s.conn.SetReadLimit(maxMessageSize)
+	alive := func() error { return s.conn.SetReadDeadline(time.Now().Add(s.reg.PongWait)) }
+	_ = alive()
+	s.conn.SetPongHandler(func(string) error { return alive() })
+	for {
+		_, b, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = alive()
+		var m Message
+		if err := json.Unmarshal(b, &m); err != nil {
+			_ = s.Send(Message{Type: MsgError, Error: "invalid message: " + err.Error()})
+			continue
+		}
+		switch m.Type {
+		case MsgHeartbeat:
+			_ = s.Send(Message{Type: MsgHeartbeat})
+		case MsgOfferAck, MsgAccept, MsgDecline, MsgLocation:
+			if err := handle(ctx, s.DriverID, m); err != nil {
+				_ = s.Send(Message{Type: MsgError, RideID: m.RideID, Error: err.Error()})
+			}
+		default:
+			_ = s.Send(Message{Type: MsgError, RideID: m.RideID, Error: "unknown message type " + string(m.Type)})
+		}
+	}
+}
+
+// writePump writes queued frames and pings the driver every PingPeriod.
+func (s *WSSession) writePump() {
+	ping := time.NewTicker(s.reg.PingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case m := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.reg.WriteWait))
+			if err := s.conn.WriteJSON(m); err != nil {
+				s.Close(websocket.CloseInternalServerErr, "write failed")
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.reg.WriteWait)); err != nil {
+				s.Close(websocket.CloseInternalServerErr, "ping failed")
+				return
+			}
+		}
+	}
+}
+
+// WSRegistry holds one session per driver.
 type WSRegistry struct {
+	// The registry pings every session each PingPeriod and drops sessions
+	// that send nothing, pongs included, for PongWait. WriteWait bounds
+	// every write.
+	PingPeriod time.Duration
+	PongWait   time.Duration
+	WriteWait  time.Duration
+
 	mu       sync.RWMutex
 	sessions map[string]*WSSession
 }
 
-func NewWSRegistry() *WSRegistry { return &WSRegistry{sessions: make(map[string]*WSSession)} }
+func NewWSRegistry() *WSRegistry {
+	return &WSRegistry{
+		PingPeriod: 25 * time.Second,
+		PongWait:   60 * time.Second,
+		WriteWait:  10 * time.Second,
+		sessions:   make(map[string]*WSSession),
+	}
+}
 
-func (r *WSRegistry) Add(driverID string, conn *websocket.Conn) {
+// Serve runs a session for driverID on conn and blocks until it ends. A
+// session the driver already had is closed with CloseReplaced.
+func (r *WSRegistry) Serve(ctx context.Context, driverID string, conn *websocket.Conn, handle MessageHandler) {
+	s := r.add(driverID, conn)
+	go s.writePump()
+	s.readPump(ctx, handle)
+	r.remove(s)
+	s.Close(websocket.CloseNormalClosure, "")
+}
+
+func (r *WSRegistry) add(driverID string, conn *websocket.Conn) *WSSession {
+	s := &WSSession{DriverID: driverID, conn: conn, reg: r, send: make(chan Message, sendBuffer), done: make(chan struct{})}
+	r.mu.Lock()
+	old := r.sessions[driverID]
+	r.sessions[driverID] = s
+	r.mu.Unlock()
+	if old != nil {
+		old.Close(CloseReplaced, "replaced by a new connection")
+	}
+	return s
+}
+
+// remove drops s if it is still its driver's session, so a closing
+// connection cannot evict a newer one.
+func (r *WSRegistry) remove(s *WSSession) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.sessions[driverID] = &WSSession{conn: conn}
+	if r.sessions[s.DriverID] == s {
+		delete(r.sessions, s.DriverID)
+	}
 }
 
-func (r *WSRegistry) Offer(driverID string, offer models.MatchOffer) error {
+// Session returns the driver's current session.
+func (r *WSRegistry) Session(driverID string) (*WSSession, bool) {
 	r.mu.RLock()
+	defer r.mu.RUnlock()
 	s, ok := r.sessions[driverID]
-	r.mu.RUnlock()
+	return s, ok
+}
+
+// Offer delivers the offer to the session of offer.DriverID.
+func (r *WSRegistry) Offer(rideID string, offer models.MatchOffer) error {
+	s, ok := r.Session(offer.DriverID)
 	if !ok {
 		return ErrNoSession
 	}
-	if err := s.Send(offer); err != nil {
+	if err := s.Send(Message{Type: MsgOffer, RideID: rideID, Offer: &offer}); err != nil {
 		log.Printf("ws send error: %v", err)
 		return err
 	}
@@ -14,6 +14,12 @@ type Client interface {
 	EstimateSeconds(from, to models.Coord) (float64, error)
 }
 
+// Router is implemented by clients that also know the route distance, such
+// as OSRMClient.
+type Router interface {
+	Route(from, to models.Coord) (meters, seconds float64, err error)
+}
+
 // Cache is a tiny in-memory cache for ETA lookups keyed by coords.
 type Cache struct {
 	mu    sync.RWMutex
@@ -75,6 +81,11 @@ func EstimateSeconds(from, to models.Coord, speedMps float64) float64 {
 	return d / speedMps
 }
 
+// DistanceMeters is the great-circle distance between two points.
+func DistanceMeters(from, to models.Coord) float64 {
+	return haversine(from.Lat, from.Lon, to.Lat, to.Lon)
+}
+
 // local haversine to avoid import cycle
 func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	const R = 6371000.0
@@ -21,24 +21,32 @@ func NewOSRMClient(endpoint string) *OSRMClient {
 
 // EstimateSeconds queries OSRM /route between points and returns duration in seconds.
 func (o *OSRMClient) EstimateSeconds(from models.Coord, to models.Coord) (float64, error) {
+	_, seconds, err := o.Route(from, to)
+	return seconds, err
+}
+
+// Route queries OSRM /route between points and returns the driving distance
+// in meters and duration in seconds.
+func (o *OSRMClient) Route(from models.Coord, to models.Coord) (float64, float64, error) {
 	// OSRM route query: /route/v1/driving/{lon1},{lat1};{lon2},{lat2}?overview=false
 	url := fmt.Sprintf("%s/route/v1/driving/%.6f,%.6f;%.6f,%.6f?overview=false", o.Endpoint, from.Lon, from.Lat, to.Lon, to.Lat)
 	resp, err := o.Client.Get(url)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	defer resp.Body.Close()
 	var out struct {
 		Routes []struct {
+			Distance float64 `json:"distance"`
 			Duration float64 `json:"duration"`
 		} `json:"routes"`
 		Code string `json:"code"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	if out.Code != "Ok" || len(out.Routes) == 0 {
-		return 0, fmt.Errorf("osrm no route: %v", out.Code)
+		return 0, 0, fmt.Errorf("osrm no route: %v", out.Code)
 	}
-	return out.Routes[0].Duration, nil
+	return out.Routes[0].Distance, out.Routes[0].Duration, nil
 }
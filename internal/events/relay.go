@@ -0,0 +1,66 @@
+// Package events relays ride events from the transactional outbox to Kafka.
+package events
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/example/ride-matching/internal/storage"
+)
+
+// Publisher delivers a batch of outbox messages; it must only return nil
+// once every message is durably written.
+type Publisher interface {
+	Publish(ctx context.Context, msgs []storage.OutboxMessage) error
+}
+
+// Relay polls the outbox and publishes pending messages until its context
+// is canceled.
+type Relay struct {
+	Outbox    storage.Outbox
+	Publisher Publisher
+	Interval  time.Duration
+	BatchSize int
+	Logger    *slog.Logger
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		r.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// drain publishes full batches back to back so a backlog clears without
+// waiting a tick per batch.
+func (r *Relay) drain(ctx context.Context) {
+	size := r.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	for ctx.Err() == nil {
+		n, err := r.Outbox.DrainOutbox(ctx, size, func(msgs []storage.OutboxMessage) error {
+			return r.Publisher.Publish(ctx, msgs)
+		})
+		if err != nil {
+			if r.Logger != nil {
+				r.Logger.Warn("outbox relay failed", "error", err)
+			}
+			return
+		}
+		if n < size {
+			return
+		}
+	}
+}
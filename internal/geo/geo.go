@@ -1,7 +1,10 @@
 package geo
 
 import (
+	"container/heap"
+	"context"
 	"math"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,63 +13,440 @@ import (
 
 // Geo is the minimal interface required by the matcher and handlers.
 type Geo interface {
-	Nearby(lat, lon float64, limit int) []models.Driver
-	Upsert(d models.Driver)
+	// Nearby returns up to opts.Limit online, unreserved drivers ordered by
+	// distance. The search starts at opts.Radius and widens by opts.Step
+	// until opts.MinCandidates drivers are found or opts.MaxRadius is
+	// reached. An empty result with a nil error means no drivers are around.
+	Nearby(lat, lon float64, opts SearchOptions) ([]models.Driver, error)
+	// Upsert records a driver's location and reports whether the driver is
+	// online afterwards. A non-empty d.Status also changes the driver's
+	// availability; otherwise a known driver keeps its current status and a
+	// new one starts online only if d.Online is set.
+	Upsert(d models.Driver) bool
+	// SetStatus changes a driver's availability. Offline drivers and drivers
+	// on a break are dropped from search until they go online again.
+	SetStatus(driverID string, status models.DriverStatus, city string) error
+	// Reserve atomically holds a driver for holder (typically a ride ID) for
+	// ttl. It succeeds when the driver is free or already held by the same
+	// holder, in which case the hold is refreshed. Held drivers are excluded
+	// from Nearby.
+	Reserve(driverID, holder string, ttl time.Duration) (bool, error)
+	// Release frees the driver if it is still held by holder.
+	Release(driverID, holder string) error
 }
 
+// OnlineCounter reports the number of online drivers per city; drivers
+// without a city are counted under "".
+type OnlineCounter interface {
+	OnlineByCity(ctx context.Context) (map[string]int, error)
+}
+
+// SearchOptions bounds a Nearby query. Zero values fall back to defaults.
+type SearchOptions struct {
+	Limit         int     // maximum drivers returned (default 10)
+	Radius        float64 // first search radius in meters (default 5000)
+	MaxRadius     float64 // widest radius in meters (default Radius, i.e. no expansion)
+	Step          float64 // radius increment in meters (default Radius)
+	MinCandidates int     // stop widening once this many drivers are found (default Limit)
+}
+
+func (o SearchOptions) withDefaults() SearchOptions {
+	if o.Limit <= 0 {
+		o.Limit = 10
+	}
+	if o.Radius <= 0 {
+		o.Radius = 5000
+	}
+	if o.MaxRadius < o.Radius {
+		o.MaxRadius = o.Radius
+	}
+	if o.Step <= 0 {
+		o.Step = o.Radius
+	}
+	if o.MinCandidates <= 0 || o.MinCandidates > o.Limit {
+		o.MinCandidates = o.Limit
+	}
+	return o
+}
+
+// radii lists the search radii from Radius up to MaxRadius.
+func (o SearchOptions) radii() []float64 {
+	var out []float64
+	for r := o.Radius; r < o.MaxRadius; r += o.Step {
+		out = append(out, r)
+	}
+	return append(out, o.MaxRadius)
+}
+
+type reservation struct {
+	holder  string
+	expires time.Time
+}
+
+// defaultCellDeg is the side of an index cell in degrees, about 1.1km of
+// latitude.
+const defaultCellDeg = 0.01
+
+const metersPerDeg = 6371000.0 * math.Pi / 180
+
+// cellKey addresses a cell of the lat/lon grid.
+//
+// The grid is deliberately not built from Geohash cells. A geohash cell is
+// itself a lat/lon rectangle, so it would bucket drivers no better, but its
+// sides are fixed powers of two (about 1.2km x 0.6km at precision 6) and
+// its neighbours have to be derived by string arithmetic. The ring search
+// needs the neighbours of a cell at any Chebyshev distance, and ringBound
+// needs the cell side; integer rows and columns of a chosen size give both
+// directly. Cells never leave the process, unlike the surge pricing cells,
+// which are geohashes because they are shared by key.
+type cellKey struct {
+	row, col int32
+}
+
+// Index is an in-memory Geo that buckets drivers into a lat/lon grid and
+// answers Nearby by searching expanding rings of cells around the query
+// point, so the cost depends on the local driver density rather than on
+// the total number of drivers.
 type Index struct {
-	mu      sync.RWMutex
-	drivers map[string]models.Driver
+	// MaxAge hides and evicts drivers not updated for this long; 0
+	// disables.
+	MaxAge time.Duration
+
+	mu       sync.RWMutex
+	cellDeg  float64
+	cols     int32
+	drivers  map[string]models.Driver
+	cellOf   map[string]cellKey
+	cells    map[cellKey]map[string]struct{}
+	reserved map[string]reservation
 }
 
 func NewIndex() *Index {
-	return &Index{drivers: make(map[string]models.Driver)}
+	return NewIndexWithCellSize(defaultCellDeg)
 }
 
-func (g *Index) Upsert(d models.Driver) {
+// NewIndexWithCellSize returns an Index whose cells are cellDeg degrees on
+// a side. Smaller cells suit dense cities; larger ones sparse regions.
+func NewIndexWithCellSize(cellDeg float64) *Index {
+	if cellDeg <= 0 {
+		cellDeg = defaultCellDeg
+	}
+	return &Index{
+		cellDeg:  cellDeg,
+		cols:     int32(math.Ceil(360 / cellDeg)),
+		drivers:  make(map[string]models.Driver),
+		cellOf:   make(map[string]cellKey),
+		cells:    make(map[cellKey]map[string]struct{}),
+		reserved: make(map[string]reservation),
+	}
+}
+
+func (g *Index) Reserve(driverID, holder string, ttl time.Duration) (bool, error) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	d.Updated = time.Now()
-	g.drivers[d.ID] = d
+	now := time.Now()
+	if cur, ok := g.reserved[driverID]; ok && cur.holder != holder && now.Before(cur.expires) {
+		return false, nil
+	}
+	g.reserved[driverID] = reservation{holder: holder, expires: now.Add(ttl)}
+	return true, nil
 }
 
-// naive scan; in prod use geo-hash or H3
-func (g *Index) Nearby(lat, lon float64, limit int) []models.Driver {
+func (g *Index) Release(driverID, holder string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if cur, ok := g.reserved[driverID]; ok && cur.holder == holder {
+		delete(g.reserved, driverID)
+	}
+	return nil
+}
+
+// isReserved reports whether the driver is held; callers must hold g.mu.
+func (g *Index) isReserved(driverID string, now time.Time) bool {
+	cur, ok := g.reserved[driverID]
+	return ok && now.Before(cur.expires)
+}
+
+// SetStatus changes the driver's availability. A driver that has not sent
+// a location yet is remembered but stays out of search until it does.
+// Updated is left alone: only a location ping makes a position fresh.
+func (g *Index) SetStatus(driverID string, status models.DriverStatus, city string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d, ok := g.drivers[driverID]
+	if !ok {
+		d = models.Driver{ID: driverID}
+	}
+	d.Status = status
+	d.Online = status == models.DriverOnline
+	if city != "" {
+		d.City = city
+	}
+	g.drivers[driverID] = d
+	return nil
+}
+
+// OnlineByCity counts online drivers with a fresh location per city.
+func (g *Index) OnlineByCity(ctx context.Context) (map[string]int, error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	type pair struct {
-		d    models.Driver
-		dist float64
+	now := time.Now()
+	out := make(map[string]int)
+	for id, d := range g.drivers {
+		if _, located := g.cellOf[id]; located && d.Online && !g.isStale(d, now) {
+			out[d.City]++
+		}
 	}
-	arr := make([]pair, 0, len(g.drivers))
-	for _, d := range g.drivers {
-		if !d.Online {
+	return out, nil
+}
+
+func (g *Index) isStale(d models.Driver, now time.Time) bool {
+	return g.MaxAge > 0 && now.Sub(d.Updated) > g.MaxAge
+}
+
+// Sweep evicts drivers not updated for MaxAge from search, along with
+// expired reservations, and returns how many drivers were removed. Their
+// status and city are kept, so a driver that went quiet comes back with
+// the availability it had.
+func (g *Index) Sweep(ctx context.Context) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for id, res := range g.reserved {
+		if !now.Before(res.expires) {
+			delete(g.reserved, id)
+		}
+	}
+	if g.MaxAge <= 0 {
+		return 0, nil
+	}
+	n := 0
+	for id, key := range g.cellOf {
+		if !g.isStale(g.drivers[id], now) {
 			continue
 		}
-		dist := Haversine(lat, lon, d.Loc.Lat, d.Loc.Lon)
-		arr = append(arr, pair{d, dist})
-	}
-	// partial selection sort for top-N
-	n := limit
-	if n > len(arr) {
-		n = len(arr)
-	}
-	for i := 0; i < n; i++ {
-		minIdx := i
-		for j := i + 1; j < len(arr); j++ {
-			if arr[j].dist < arr[minIdx].dist {
-				minIdx = j
+		members := g.cells[key]
+		delete(members, id)
+		if len(members) == 0 {
+			delete(g.cells, key)
+		}
+		delete(g.cellOf, id)
+		n++
+	}
+	return n, nil
+}
+
+func (g *Index) cell(lat, lon float64) cellKey {
+	row := int32(math.Floor(lat / g.cellDeg))
+	col := int32(math.Floor((lon + 180) / g.cellDeg))
+	return cellKey{row: row, col: g.wrap(col)}
+}
+
+// wrap folds a column index across the antimeridian.
+func (g *Index) wrap(col int32) int32 {
+	col %= g.cols
+	if col < 0 {
+		col += g.cols
+	}
+	return col
+}
+
+// Upsert stores the driver and moves it to the cell of its new position.
+func (g *Index) Upsert(d models.Driver) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	prev, known := g.drivers[d.ID]
+	switch {
+	case d.Status != "":
+		d.Online = d.Status == models.DriverOnline
+	case known:
+		d.Status, d.Online = prev.Status, prev.Online
+	case d.Online:
+		d.Status = models.DriverOnline
+	}
+	if d.City == "" {
+		d.City = prev.City
+	}
+	if d.Rating == 0 {
+		d.Rating = prev.Rating
+	}
+	d.Updated = time.Now()
+	g.drivers[d.ID] = d
+	key := g.cell(d.Loc.Lat, d.Loc.Lon)
+	if old, ok := g.cellOf[d.ID]; ok {
+		if old == key {
+			return d.Online
+		}
+		members := g.cells[old]
+		delete(members, d.ID)
+		if len(members) == 0 {
+			delete(g.cells, old)
+		}
+	}
+	members, ok := g.cells[key]
+	if !ok {
+		members = make(map[string]struct{})
+		g.cells[key] = members
+	}
+	members[d.ID] = struct{}{}
+	g.cellOf[d.ID] = key
+	return d.Online
+}
+
+// Nearby finds the closest drivers within opts.MaxRadius and then keeps
+// those inside the smallest expansion radius that yields MinCandidates,
+// which is what widening the search ring by ring would return.
+func (g *Index) Nearby(lat, lon float64, opts SearchOptions) ([]models.Driver, error) {
+	opts = opts.withDefaults()
+	best := g.nearest(lat, lon, opts.Limit, opts.MaxRadius)
+	n := len(best)
+	for _, r := range opts.radii() {
+		within := sort.Search(len(best), func(i int) bool { return best[i].dist > r })
+		if within >= opts.MinCandidates {
+			n = within
+			break
+		}
+	}
+	out := make([]models.Driver, n)
+	for i := range out {
+		out[i] = best[i].d
+	}
+	return out, nil
+}
+
+// nearest returns up to limit online, unreserved drivers no further than
+// maxDist, closest first. Rings of cells are visited outwards until no
+// unvisited cell can hold a driver closer than the current limit-th result.
+// Once a ring has more cells than the index has occupied cells, the
+// remaining occupied cells are scanned directly so sparse indexes do not
+// walk empty rings.
+func (g *Index) nearest(lat, lon float64, limit int, maxDist float64) []scored {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	now := time.Now()
+	best := make(nearestHeap, 0, limit)
+	visitCell := func(key cellKey) {
+		for id := range g.cells[key] {
+			d := g.drivers[id]
+			if !d.Online || g.isReserved(id, now) || g.isStale(d, now) {
+				continue
+			}
+			if dist := Haversine(lat, lon, d.Loc.Lat, d.Loc.Lon); dist <= maxDist {
+				best.offer(d, dist, limit)
+			}
+		}
+	}
+
+	center := g.cell(lat, lon)
+	for k := int32(0); ; k++ {
+		if 8*int(k) > len(g.cells) || 2*k+1 >= g.cols {
+			for key := range g.cells {
+				if g.ringOf(center, key) >= k {
+					visitCell(key)
+				}
 			}
+			break
+		}
+		g.visitRing(center, k, visitCell)
+		bound := g.ringBound(lat, k)
+		if bound > maxDist || (len(best) == limit && best[0].dist <= bound) {
+			break
 		}
-		arr[i], arr[minIdx] = arr[minIdx], arr[i]
 	}
-	out := make([]models.Driver, 0, n)
-	for i := 0; i < n; i++ {
-		out = append(out, arr[i].d)
+
+	out := make([]scored, len(best))
+	for i := len(best) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&best).(scored)
 	}
 	return out
 }
 
+// visitRing calls fn for every cell at Chebyshev distance k from center.
+func (g *Index) visitRing(center cellKey, k int32, fn func(cellKey)) {
+	visit := func(row, col int32) {
+		fn(cellKey{row: row, col: g.wrap(col)})
+	}
+	if k == 0 {
+		visit(center.row, center.col)
+		return
+	}
+	for dc := -k; dc <= k; dc++ {
+		visit(center.row-k, center.col+dc)
+		visit(center.row+k, center.col+dc)
+	}
+	for dr := -k + 1; dr <= k-1; dr++ {
+		visit(center.row+dr, center.col-k)
+		visit(center.row+dr, center.col+k)
+	}
+}
+
+// ringOf is the Chebyshev distance between two cells, across the
+// antimeridian where that is shorter.
+func (g *Index) ringOf(a, b cellKey) int32 {
+	dr := a.row - b.row
+	if dr < 0 {
+		dr = -dr
+	}
+	dc := a.col - b.col
+	if dc < 0 {
+		dc = -dc
+	}
+	if g.cols-dc < dc {
+		dc = g.cols - dc
+	}
+	return max(dr, dc)
+}
+
+// ringBound is a lower bound on the distance from the point to any driver
+// outside rings 0..k. Longitude cells shrink towards the poles, so the
+// narrowest cell in the band the next ring spans is used; the 0.99 factor
+// covers the gap between a parallel and the great circle at city scale.
+func (g *Index) ringBound(lat float64, k int32) float64 {
+	edgeLat := math.Min(math.Abs(lat)+float64(k+1)*g.cellDeg, 89.9)
+	return 0.99 * float64(k) * g.cellDeg * metersPerDeg * math.Cos(edgeLat*math.Pi/180)
+}
+
+type scored struct {
+	d    models.Driver
+	dist float64
+}
+
+// nearestHeap is a max-heap on distance holding the best candidates so far.
+type nearestHeap []scored
+
+func (h nearestHeap) Len() int           { return len(h) }
+func (h nearestHeap) Less(i, j int) bool { return h[i].dist > h[j].dist }
+func (h nearestHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nearestHeap) Push(x any)        { *h = append(*h, x.(scored)) }
+func (h *nearestHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// offer keeps d if it is among the limit closest seen so far.
+func (h *nearestHeap) offer(d models.Driver, dist float64, limit int) {
+	if len(*h) < limit {
+		heap.Push(h, scored{d, dist})
+		return
+	}
+	if dist < (*h)[0].dist {
+		(*h)[0] = scored{d, dist}
+		heap.Fix(h, 0)
+	}
+}
+
+// Bearing is the initial compass bearing in degrees [0, 360) from the first
+// point to the second.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	rlat1, rlat2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	y := math.Sin(dLon) * math.Cos(rlat2)
+	x := math.Cos(rlat1)*math.Sin(rlat2) - math.Sin(rlat1)*math.Cos(rlat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
 // Haversine distance in meters
 func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	const R = 6371000.0
@@ -1,10 +1,518 @@
 package geo
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/example/ride-matching/internal/models"
+	"github.com/redis/go-redis/v9"
+)
 
 func TestHaversineZero(t *testing.T) {
-    d := Haversine(0,0,0,0)
-    if d != 0 {
-        t.Fatalf("expected 0, got %f", d)
-    }
+	d := Haversine(0, 0, 0, 0)
+	if d != 0 {
+		t.Fatalf("expected 0, got %f", d)
+	}
+}
+
+func TestBearingCardinalDirections(t *testing.T) {
+	for _, c := range []struct {
+		lat, lon, want float64
+	}{{1, 0, 0}, {0, 1, 90}, {-1, 0, 180}, {0, -1, 270}} {
+		if got := Bearing(0, 0, c.lat, c.lon); math.Abs(got-c.want) > 1e-9 {
+			t.Fatalf("bearing to (%v,%v) = %v, want %v", c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func TestGeohashKnownPoint(t *testing.T) {
+	// reference value from the original geohash.org announcement
+	if got := Geohash(57.64911, 10.40744, 11); got != "u4pruydqqvj" {
+		t.Fatalf("expected u4pruydqqvj, got %s", got)
+	}
+	if got := Geohash(57.64911, 10.40744, 6); got != "u4pruy" {
+		t.Fatalf("expected u4pruy, got %s", got)
+	}
+}
+
+// scanNearby is the previous full-scan Index.Nearby, kept as the reference
+// for correctness checks and benchmarks.
+func scanNearby(drivers map[string]models.Driver, lat, lon float64, limit int) []models.Driver {
+	type pair struct {
+		d    models.Driver
+		dist float64
+	}
+	arr := make([]pair, 0, len(drivers))
+	for _, d := range drivers {
+		if !d.Online {
+			continue
+		}
+		arr = append(arr, pair{d, Haversine(lat, lon, d.Loc.Lat, d.Loc.Lon)})
+	}
+	n := min(limit, len(arr))
+	for i := 0; i < n; i++ {
+		minIdx := i
+		for j := i + 1; j < len(arr); j++ {
+			if arr[j].dist < arr[minIdx].dist {
+				minIdx = j
+			}
+		}
+		arr[i], arr[minIdx] = arr[minIdx], arr[i]
+	}
+	out := make([]models.Driver, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, arr[i].d)
+	}
+	return out
+}
+
+// randomDrivers scatters n drivers over a ~110km square around San Francisco.
+func randomDrivers(rng *rand.Rand, n int) map[string]models.Driver {
+	out := make(map[string]models.Driver, n)
+	for i := 0; i < n; i++ {
+		d := models.Driver{
+			ID:     fmt.Sprintf("d%d", i),
+			Loc:    models.Coord{Lat: 37.3 + rng.Float64(), Lon: -122.9 + rng.Float64()},
+			Rating: 4.5,
+			Online: rng.Intn(10) != 0,
+		}
+		out[d.ID] = d
+	}
+	return out
+}
+
+func sameDistances(t *testing.T, lat, lon float64, got, want []models.Driver) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("(%f,%f): got %d drivers, want %d", lat, lon, len(got), len(want))
+	}
+	for i := range got {
+		// ties may come back in either order, so compare distances
+		g := Haversine(lat, lon, got[i].Loc.Lat, got[i].Loc.Lon)
+		w := Haversine(lat, lon, want[i].Loc.Lat, want[i].Loc.Lon)
+		if math.Abs(g-w) > 1e-6 {
+			t.Fatalf("(%f,%f) #%d: got %s at %.1fm, want %s at %.1fm", lat, lon, i, got[i].ID, g, want[i].ID, w)
+		}
+	}
+}
+
+func TestIndexNearbyMatchesScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	drivers := randomDrivers(rng, 5000)
+	idx := NewIndex()
+	for _, d := range drivers {
+		idx.Upsert(d)
+	}
+	// move a third of the drivers so they change cells
+	for id, d := range drivers {
+		if rng.Intn(3) == 0 {
+			d.Loc = models.Coord{Lat: 37.3 + rng.Float64(), Lon: -122.9 + rng.Float64()}
+			drivers[id] = d
+			idx.Upsert(d)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		// include queries outside the populated area
+		lat, lon := 37.0+1.6*rng.Float64(), -123.2+1.6*rng.Float64()
+		limit := 1 + rng.Intn(20)
+		got, err := idx.Nearby(lat, lon, SearchOptions{Limit: limit, Radius: 1e7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameDistances(t, lat, lon, got, scanNearby(drivers, lat, lon, limit))
+	}
+}
+
+func TestIndexNearbySparseAndAntimeridian(t *testing.T) {
+	idx := NewIndex()
+	idx.Upsert(models.Driver{ID: "east", Loc: models.Coord{Lat: 0, Lon: 179.999}, Online: true})
+	idx.Upsert(models.Driver{ID: "far", Loc: models.Coord{Lat: 40.005, Lon: -73.995}, Online: true})
+	wide := SearchOptions{Limit: 5, Radius: 2.1e7} // half the circumference
+	got, _ := idx.Nearby(0, -179.999, wide)
+	if len(got) != 2 || got[0].ID != "east" || got[1].ID != "far" {
+		t.Fatalf("unexpected result %+v", got)
+	}
+
+	// moving a driver removes it from its old cell
+	idx.Upsert(models.Driver{ID: "east", Loc: models.Coord{Lat: 40.006, Lon: -73.994}, Online: true})
+	if got, _ := idx.Nearby(0, -179.999, wide); got[0].ID != "far" {
+		t.Fatalf("expected far to be closest after move, got %s", got[0].ID)
+	}
+	if len(idx.cells) != 1 {
+		t.Fatalf("expected empty cells to be dropped, have %d", len(idx.cells))
+	}
+}
+
+func TestIndexNearbyExpandsRadius(t *testing.T) {
+	idx := NewIndex()
+	// drivers roughly 1km, 3km and 6km north of the origin
+	for i, km := range []float64{1, 3, 6} {
+		idx.Upsert(models.Driver{ID: fmt.Sprintf("d%d", i), Loc: models.Coord{Lat: km / 111.2, Lon: 0}, Online: true})
+	}
+	cases := []struct {
+		opts SearchOptions
+		want int
+	}{
+		{SearchOptions{Limit: 10, Radius: 2000}, 1},                                                 // no expansion
+		{SearchOptions{Limit: 10, Radius: 2000, MaxRadius: 10000, Step: 2000, MinCandidates: 2}, 2}, // stops at 4km
+		{SearchOptions{Limit: 10, Radius: 2000, MaxRadius: 10000, Step: 2000, MinCandidates: 5}, 3}, // widens to the max
+		{SearchOptions{Limit: 10, Radius: 2000, MaxRadius: 5000, Step: 2000, MinCandidates: 5}, 2},  // capped at 5km
+		{SearchOptions{Limit: 1, Radius: 500, MaxRadius: 10000, Step: 500}, 1},                      // limit wins
+	}
+	for i, c := range cases {
+		got, err := idx.Nearby(0, 0, c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != c.want {
+			t.Fatalf("case %d: got %d drivers, want %d", i, len(got), c.want)
+		}
+	}
+}
+
+func TestIndexSkipsAndSweepsStaleDrivers(t *testing.T) {
+	idx := NewIndex()
+	idx.MaxAge = 50 * time.Millisecond
+	idx.Upsert(models.Driver{ID: "stale", Online: true})
+	time.Sleep(80 * time.Millisecond)
+	idx.Upsert(models.Driver{ID: "fresh", Online: true})
+
+	got, _ := idx.Nearby(0, 0, SearchOptions{Limit: 5})
+	if len(got) != 1 || got[0].ID != "fresh" {
+		t.Fatalf("expected only the fresh driver, got %+v", got)
+	}
+	n, err := idx.Sweep(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("sweep = %d, %v; want 1 eviction", n, err)
+	}
+	if _, ok := idx.cellOf["stale"]; ok {
+		t.Fatal("stale driver still indexed after sweep")
+	}
+	if len(idx.cells) != 1 || len(idx.cellOf) != 1 {
+		t.Fatalf("cells not cleaned up: %d cells, %d drivers", len(idx.cells), len(idx.cellOf))
+	}
+	if counts, _ := idx.OnlineByCity(context.Background()); counts[""] != 1 {
+		t.Fatalf("swept driver still counted online: %v", counts)
+	}
+	// pings never carry availability; the swept driver keeps its status
+	if !idx.Upsert(models.Driver{ID: "stale"}) {
+		t.Fatal("driver swept while online came back offline")
+	}
+	if n, _ := idx.Sweep(context.Background()); n != 0 {
+		t.Fatalf("sweep evicted %d fresh drivers", n)
+	}
+}
+
+func TestIndexStatusChangeDoesNotRefreshLocation(t *testing.T) {
+	idx := NewIndex()
+	idx.MaxAge = 50 * time.Millisecond
+	idx.Upsert(models.Driver{ID: "d1", Online: true})
+	_ = idx.SetStatus("d1", models.DriverOffline, "")
+	time.Sleep(80 * time.Millisecond)
+	// going online hours after the last ping must not revive the old position
+	_ = idx.SetStatus("d1", models.DriverOnline, "")
+	if got, _ := idx.Nearby(0, 0, SearchOptions{Limit: 5}); len(got) != 0 {
+		t.Fatalf("driver offered at a stale position: %+v", got)
+	}
+	idx.Upsert(models.Driver{ID: "d1"})
+	if got, _ := idx.Nearby(0, 0, SearchOptions{Limit: 5}); len(got) != 1 {
+		t.Fatalf("expected d1 back after a ping, got %+v", got)
+	}
+}
+
+func TestRedisGeoSweepKeepsStatus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := NewRedisGeo(mr.Addr(), "", 0, "drivers_geo")
+	r.MaxAge = time.Minute
+	ctx := context.Background()
+	_ = r.SetStatus("d1", models.DriverOnline, "sf")
+	r.Upsert(models.Driver{ID: "d1", Loc: models.Coord{Lat: 37.77, Lon: -122.41}})
+	if _, err := mr.ZAdd(SeenKey("drivers_geo"), float64(time.Now().Add(-time.Hour).UnixMilli()), "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Sweep(ctx); n != 1 || err != nil {
+		t.Fatalf("sweep = %d, %v; want 1 eviction", n, err)
+	}
+	if counts, _ := r.OnlineByCity(ctx); counts["sf"] != 0 {
+		t.Fatalf("swept driver still counted online: %v", counts)
+	}
+	// going online again without a location must not count the driver
+	_ = r.SetStatus("d1", models.DriverOnline, "")
+	if counts, _ := r.OnlineByCity(ctx); counts["sf"] != 0 {
+		t.Fatalf("driver without a location counted online: %v", counts)
+	}
+
+	if !r.Upsert(models.Driver{ID: "d1", Loc: models.Coord{Lat: 37.77, Lon: -122.41}}) {
+		t.Fatal("driver swept while online came back offline")
+	}
+	if got, _ := r.Nearby(37.77, -122.41, SearchOptions{Limit: 5}); len(got) != 1 {
+		t.Fatalf("expected d1 back in search, got %+v", got)
+	}
+	if counts, _ := r.OnlineByCity(ctx); counts["sf"] != 1 {
+		t.Fatalf("online by city = %v", counts)
+	}
+}
+
+func TestIndexDriverStatus(t *testing.T) {
+	idx := NewIndex()
+	ctx := context.Background()
+	_ = idx.SetStatus("d1", models.DriverOnline, "sf")
+	idx.Upsert(models.Driver{ID: "d1", Rating: 5})
+	idx.Upsert(models.Driver{ID: "d2", Online: true}) // legacy ping, no status
+	idx.Upsert(models.Driver{ID: "d3"})
+
+	ids := func() map[string]bool {
+		got, _ := idx.Nearby(0, 0, SearchOptions{Limit: 5})
+		out := make(map[string]bool)
+		for _, d := range got {
+			out[d.ID] = true
+		}
+		return out
+	}
+	if got := ids(); !got["d1"] || !got["d2"] || got["d3"] {
+		t.Fatalf("expected d1 and d2 online, got %v", got)
+	}
+	if counts, _ := idx.OnlineByCity(ctx); counts["sf"] != 1 || counts[""] != 1 {
+		t.Fatalf("online by city = %v", counts)
+	}
+
+	_ = idx.SetStatus("d1", models.DriverBreak, "")
+	// a ping during the break must not bring the driver back
+	idx.Upsert(models.Driver{ID: "d1", Online: true})
+	if got := ids(); got["d1"] {
+		t.Fatalf("driver on a break returned by search: %v", got)
+	}
+	if counts, _ := idx.OnlineByCity(ctx); counts["sf"] != 0 {
+		t.Fatalf("driver on a break still counted online: %v", counts)
+	}
+
+	_ = idx.SetStatus("d1", models.DriverOnline, "")
+	if got := ids(); !got["d1"] {
+		t.Fatalf("driver back online missing from search: %v", got)
+	}
+	if idx.drivers["d1"].City != "sf" {
+		t.Fatalf("city lost across status changes: %+v", idx.drivers["d1"])
+	}
+}
+
+func TestRedisGeoNearbyFiltersInScript(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := NewRedisGeo(mr.Addr(), "", 0, "drivers_geo")
+	r.MaxAge = time.Minute
+	at := func(id string, dLat float64) models.Driver {
+		return models.Driver{ID: id, Loc: models.Coord{Lat: 37.77 + dLat, Lon: -122.41}, Rating: 4.5, Online: true}
+	}
+	recorded := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+	for i, id := range []string{"near", "reserved", "offline", "stale", "far"} {
+		d := at(id, float64(i)*0.001)
+		d.Speed, d.Accuracy, d.RecordedAt = 8.5, 12, recorded
+		if id != "far" {
+			h := 270.0
+			d.Heading = &h
+		}
+		if !r.Upsert(d) {
+			t.Fatalf("%s: expected a new driver to be online", id)
+		}
+	}
+	_ = r.SetStatus("offline", models.DriverOffline, "")
+	if r.Upsert(at("offline", 0.002)) {
+		t.Fatal("a ping must not bring an offline driver back online")
+	}
+	// a dropped out-of-order ping still reports the stored availability
+	late := at("near", 0)
+	late.RecordedAt = recorded.Add(-time.Second)
+	if !r.Upsert(late) {
+		t.Fatal("expected stale ping to report near as online")
+	}
+	if ok, _ := r.Reserve("reserved", "ride1", time.Minute); !ok {
+		t.Fatal("reserve failed")
+	}
+	if _, err := mr.ZAdd(SeenKey("drivers_geo"), float64(time.Now().Add(-time.Hour).UnixMilli()), "stale"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Nearby(37.77, -122.41, SearchOptions{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "near" || got[1].ID != "far" {
+		t.Fatalf("expected near and far, got %+v", got)
+	}
+	d := got[0]
+	if d.Rating != 4.5 || !d.Online || d.Updated.IsZero() || time.Since(d.Updated) > time.Minute {
+		t.Fatalf("metadata not hydrated: %+v", d)
+	}
+	if d.Heading == nil || *d.Heading != 270 || d.Speed != 8.5 || d.Accuracy != 12 || !d.RecordedAt.Equal(recorded) {
+		t.Fatalf("motion not hydrated: %+v", d)
+	}
+	if got[1].Heading != nil {
+		t.Fatalf("far reported no heading, read back %v", *got[1].Heading)
+	}
+	if math.Abs(d.Loc.Lat-37.77) > 1e-4 || math.Abs(d.Loc.Lon+122.41) > 1e-4 {
+		t.Fatalf("location = %+v", d.Loc)
+	}
+}
+
+func TestDriverStateWriterDropsOutOfOrderPings(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	a := NewDriverStateWriter(rc, "drivers_geo")
+	ctx := context.Background()
+	t0 := time.Now().Truncate(time.Millisecond)
+	ping := func(lat float64, at time.Time, seq uint64) bool {
+		t.Helper()
+		d := &models.Driver{ID: "d1", Loc: models.Coord{Lat: lat, Lon: 2}, Online: true, RecordedAt: at, Seq: seq}
+		applied, err := a.ApplyLocation(ctx, d, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return applied
+	}
+
+	if !ping(1.0, t0, 1) {
+		t.Fatal("first ping dropped")
+	}
+	if !ping(1.1, t0.Add(time.Second), 2) {
+		t.Fatal("newer ping dropped")
+	}
+	if ping(1.05, t0.Add(500*time.Millisecond), 3) {
+		t.Fatal("ping with an older device time applied")
+	}
+	if ping(1.2, t0.Add(time.Second), 2) {
+		t.Fatal("duplicate ping applied")
+	}
+	if !ping(1.3, t0.Add(time.Second), 3) {
+		t.Fatal("ping with the same time and a higher sequence dropped")
+	}
+
+	pos, err := rc.GeoPos(ctx, "drivers_geo", "d1").Result()
+	if err != nil || len(pos) != 1 || pos[0] == nil {
+		t.Fatalf("geopos: %v %v", pos, err)
+	}
+	if pos[0].Latitude < 1.29 || pos[0].Latitude > 1.31 {
+		t.Fatalf("latitude = %v, want the last applied ping (1.3)", pos[0].Latitude)
+	}
+	if _, err := rc.ZScore(ctx, SeenKey("drivers_geo"), "d1").Result(); err != nil {
+		t.Fatalf("last-seen not recorded: %v", err)
+	}
+	if online, _ := rc.HGet(ctx, "driver:meta:d1", "online").Result(); online != "true" {
+		t.Fatalf("online = %q, want seeded true", online)
+	}
+}
+
+func TestDriverStateWriterPipelinesInOrder(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := NewDriverStateWriter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "drivers_geo")
+	ds := []*models.Driver{
+		{ID: "d1", Loc: models.Coord{Lat: 1, Lon: 2}, Seq: 1},
+		{ID: "d2", Loc: models.Coord{Lat: 1, Lon: 2}, Seq: 1},
+		{ID: "d1", Loc: models.Coord{Lat: 1, Lon: 2}, Seq: 3},
+		{ID: "d1", Loc: models.Coord{Lat: 1, Lon: 2}, Seq: 2},
+	}
+	// the script is not loaded yet, which exercises the NOSCRIPT fallback
+	applied, errs := a.ApplyLocations(context.Background(), ds, time.Now())
+	want := []bool{true, true, true, false}
+	for i := range ds {
+		if errs[i] != nil || applied[i] != want[i] {
+			t.Fatalf("ping %d: applied=%v err=%v, want %v", i, applied[i], errs[i], want[i])
+		}
+	}
+}
+
+func TestRedisGeoNearbySkipsManyReservedDrivers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := NewRedisGeo(mr.Addr(), "", 0, "drivers_geo")
+	for i := 0; i < 10; i++ {
+		id := "d" + strconv.Itoa(i)
+		r.Upsert(models.Driver{ID: id, Loc: models.Coord{Lat: 37.77 + float64(i)*0.001, Lon: -122.41}, Online: true})
+		// all but the farthest are on other rides
+		if i < 9 {
+			_, _ = r.Reserve(id, "ride"+id, time.Minute)
+		}
+	}
+	got, err := r.Nearby(37.77, -122.41, SearchOptions{Limit: 2, MinCandidates: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "d9" {
+		t.Fatalf("expected the free driver behind the reserved ones, got %+v", got)
+	}
+}
+
+func TestRedisGeoReadsConsumerWrites(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// a non-default key: the consumer and the server must agree on it
+	r := NewRedisGeo(mr.Addr(), "", 0, "fleet:geo")
+	w := NewDriverStateWriter(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "fleet:geo")
+	ctx := context.Background()
+	if err := r.SetStatus("d1", models.DriverOnline, "sf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ApplyLocation(ctx, &models.Driver{ID: "d1", Loc: models.Coord{Lat: 37.77, Lon: -122.41}, Rating: 4.9, Speed: 3.5}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Nearby(37.77, -122.41, SearchOptions{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Rating != 4.9 || got[0].Speed != 3.5 || got[0].City != "sf" {
+		t.Fatalf("consumer write not visible to the server: %+v", got)
+	}
+	if v := mr.HGet("driver:meta:d1", "schema"); v != strconv.Itoa(SchemaVersion) {
+		t.Fatalf("schema = %q, want %d", v, SchemaVersion)
+	}
+	if counts, _ := r.OnlineByCity(ctx); counts["sf"] != 1 {
+		t.Fatalf("online by city = %v", counts)
+	}
+	// a telemetry ping carries no rating and must not clear the stored one
+	r.Upsert(models.Driver{ID: "d1", Loc: models.Coord{Lat: 37.77, Lon: -122.41}})
+	if v := mr.HGet("driver:meta:d1", "rating"); v != "4.9" {
+		t.Fatalf("rating = %q after a ping without one, want 4.9", v)
+	}
+}
+
+// BenchmarkRedisNearby measures the scripted search against an in-process
+// Redis: go test -run=^$ -bench=RedisNearby ./internal/geo
+func BenchmarkRedisNearby(b *testing.B) {
+	mr := miniredis.RunT(b)
+	r := NewRedisGeo(mr.Addr(), "", 0, "drivers_geo")
+	rng := rand.New(rand.NewSource(1))
+	for _, d := range randomDrivers(rng, 2_000) {
+		r.Upsert(d)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Nearby(37.3+rng.Float64(), -122.9+rng.Float64(), SearchOptions{Limit: 8}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNearby compares the bucketed Index with the full scan it
+// replaced: go test -run=^$ -bench=Nearby ./internal/geo
+func BenchmarkNearby(b *testing.B) {
+	for _, n := range []int{10_000, 100_000, 1_000_000} {
+		rng := rand.New(rand.NewSource(1))
+		drivers := randomDrivers(rng, n)
+		idx := NewIndex()
+		for _, d := range drivers {
+			idx.Upsert(d)
+		}
+		b.Run(fmt.Sprintf("scan/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanNearby(drivers, 37.3+rng.Float64(), -122.9+rng.Float64(), 10)
+			}
+		})
+		b.Run(fmt.Sprintf("bucketed/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = idx.Nearby(37.3+rng.Float64(), -122.9+rng.Float64(), SearchOptions{Limit: 10})
+			}
+		})
+	}
 }
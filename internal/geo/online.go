@@ -0,0 +1,45 @@
+package geo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/example/ride-matching/internal/observability"
+)
+
+// unknownCity labels online drivers that never reported a city.
+const unknownCity = "unknown"
+
+// RunOnlineGauge recomputes observability.DriversOnline from c every
+// interval until ctx is canceled. Cities that no longer have online drivers
+// are dropped from the gauge rather than left at their last value.
+func RunOnlineGauge(ctx context.Context, c OnlineCounter, interval time.Duration, logger *slog.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		refreshOnlineGauge(ctx, c, logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func refreshOnlineGauge(ctx context.Context, c OnlineCounter, logger *slog.Logger) {
+	counts, err := c.OnlineByCity(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("count online drivers failed", "error", err)
+		}
+		return
+	}
+	observability.DriversOnline.Reset()
+	for city, n := range counts {
+		if city == "" {
+			city = unknownCity
+		}
+		observability.DriversOnline.WithLabelValues(city).Add(float64(n))
+	}
+}
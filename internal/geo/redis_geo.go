@@ -10,49 +10,248 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-// RedisGeo implements Geo using Redis GEO commands.
+// reserveScript takes the driver lock when it is free or already owned by the
+// caller, refreshing its TTL either way.
+var reserveScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur == false or cur == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return 1
+end
+return 0
+`)
+
+// releaseScript deletes the driver lock only if the caller still owns it.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// sweepScript removes up to ARGV[2] drivers last seen at or before ARGV[1]
+// (unix ms) from the GEO set, the last-seen set and the online hash in one
+// step, so a driver pinging mid-sweep is never half evicted. The metadata
+// hash is kept: it holds the driver's status, which must survive a gap in
+// pings such as a tunnel.
+var sweepScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('ZREM', KEYS[2], id)
+  redis.call('HDEL', KEYS[3], id)
+end
+return #ids
+`)
+
+// nearbyScript runs GEOSEARCH and hydrates the hits in one round trip. It
+// returns up to ARGV[5] hits, nearest first, that are not reserved, were
+// seen after ARGV[6] (unix ms) and are online ("true", or "1" as older
+// consumers wrote it), as rows of id, lon, lat, last-seen and the
+// nearbyFields metadata. It fetches ARGV[4] hits at first and doubles that
+// until enough pass or the radius holds no more, so a crowd of reserved or
+// offline drivers near the rider cannot hide free ones behind them. It
+// builds the lock and metadata keys itself, which pins all keys to one
+// Redis node.
+var nearbyScript = redis.NewScript(`
+local count = tonumber(ARGV[4])
+local limit = tonumber(ARGV[5])
+local cutoff = tonumber(ARGV[6])
+local out = {}
+local checked = 0
+while true do
+  local hits = redis.call('GEOSEARCH', KEYS[1], 'FROMLONLAT', ARGV[1], ARGV[2], 'BYRADIUS', ARGV[3], 'm', 'ASC', 'COUNT', count, 'WITHCOORD')
+  for i = checked + 1, #hits do
+    if #out >= limit then
+      return out
+    end
+    local hit = hits[i]
+    local id = hit[1]
+    local seen = redis.call('ZSCORE', KEYS[2], id)
+    if seen and tonumber(seen) > cutoff and redis.call('EXISTS', ARGV[8] .. id) == 0 then
+      local m = redis.call('HMGET', ARGV[7] .. id, 'online', 'rating', 'updated', 'status', 'city',
+        'heading', 'speed', 'accuracy', 'recorded_at')
+      if m[1] == 'true' or m[1] == '1' then
+        local row = {id, hit[2][1], hit[2][2], seen}
+        for j = 2, #m do
+          row[#row + 1] = m[j] or ''
+        end
+        table.insert(out, row)
+      end
+    end
+  end
+  if #out >= limit or #hits < count then
+    return out
+  end
+  checked = #hits
+  count = count * 2
+end
+`)
+
+const sweepBatch = 1000
+
+// RedisGeo implements Geo using Redis GEO commands. Every write also
+// records the driver in a sorted set of last-seen times (SeenKey), which
+// Nearby uses to skip stale drivers and Sweep uses to evict them, and
+// online drivers are tracked by city in a hash (OnlineKey).
 type RedisGeo struct {
 	client *redis.Client
 	key    string
 	ctx    context.Context
+	writer *DriverStateWriter
+	// MaxAge hides and evicts drivers not seen for this long; 0 disables.
+	MaxAge time.Duration
+}
+
+func NewRedisGeo(addr, password string, db int, key string) *RedisGeo {
+	c := redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})
+	return &RedisGeo{client: c, key: key, ctx: context.Background(), writer: NewDriverStateWriter(c, key)}
+}
+
+// Upsert records a location ping through the shared DriverStateWriter and
+// reports the driver's stored availability; a failed write reports false.
+func (r *RedisGeo) Upsert(d models.Driver) bool {
+	_, online, _ := r.writer.applyLocation(r.ctx, &d, time.Now())
+	return online
+}
+
+// SetStatus changes the driver's availability.
+func (r *RedisGeo) SetStatus(driverID string, status models.DriverStatus, city string) error {
+	return r.writer.SetStatus(r.ctx, driverID, status, city)
+}
+
+// OnlineByCity counts the drivers in the online hash per city.
+func (r *RedisGeo) OnlineByCity(ctx context.Context) (map[string]int, error) {
+	cities, err := r.client.HVals(ctx, OnlineKey(r.key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int)
+	for _, c := range cities {
+		out[c]++
+	}
+	return out, nil
+}
+
+// Sweep evicts drivers not seen for MaxAge and returns how many were
+// removed.
+func (r *RedisGeo) Sweep(ctx context.Context) (int, error) {
+	if r.MaxAge <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-r.MaxAge).UnixMilli()
+	total := 0
+	for {
+		n, err := sweepScript.Run(ctx, r.client, []string{r.key, SeenKey(r.key), OnlineKey(r.key)}, cutoff, sweepBatch).Int()
+		total += n
+		if err != nil || n < sweepBatch {
+			return total, err
+		}
+	}
 }
 
-func NewRedisGeo(addr, password, key string) *RedisGeo {
-	c := redis.NewClient(&redis.Options{Addr: addr, Password: password})
-	return &RedisGeo{client: c, key: key, ctx: context.Background()}
+func (r *RedisGeo) Reserve(driverID, holder string, ttl time.Duration) (bool, error) {
+	n, err := reserveScript.Run(r.ctx, r.client, []string{lockKey(driverID)}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
 
-func (r *RedisGeo) Upsert(d models.Driver) {
-	// store as GEOADD and HMSET for metadata
-	_, _ = r.client.GeoAdd(r.ctx, r.key, &redis.GeoLocation{Longitude: d.Loc.Lon, Latitude: d.Loc.Lat, Name: d.ID}).Result()
-	_ = r.client.HSet(r.ctx, metaKey(d.ID), map[string]interface{}{"rating": fmt.Sprintf("%f", d.Rating), "online": strconv.FormatBool(d.Online), "updated": time.Now().Format(time.RFC3339)}).Err()
+func (r *RedisGeo) Release(driverID, holder string) error {
+	return releaseScript.Run(r.ctx, r.client, []string{lockKey(driverID)}, holder).Err()
 }
 
-func (r *RedisGeo) Nearby(lat, lon float64, limit int) []models.Driver {
-	res, err := r.client.GeoRadius(r.ctx, r.key, lon, lat, &redis.GeoRadiusQuery{Radius: 5000, Unit: "m", WithCoord: true, WithDist: true, Count: limit, Sort: "ASC"}).Result()
+func (r *RedisGeo) Nearby(lat, lon float64, opts SearchOptions) ([]models.Driver, error) {
+	opts = opts.withDefaults()
+	var out []models.Driver
+	for _, radius := range opts.radii() {
+		var err error
+		out, err = r.search(lat, lon, radius, opts.Limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) >= opts.MinCandidates {
+			break
+		}
+	}
+	return out, nil
+}
+
+// search runs nearbyScript for one radius and decodes its rows.
+func (r *RedisGeo) search(lat, lon, radius float64, limit int) ([]models.Driver, error) {
+	var cutoff int64
+	if r.MaxAge > 0 {
+		cutoff = time.Now().Add(-r.MaxAge).UnixMilli()
+	}
+	// the first fetch over-fetches so a few reserved and offline drivers
+	// cost no extra GEOSEARCH; the script widens it when more are skipped
+	res, err := nearbyScript.Run(r.ctx, r.client, []string{r.key, SeenKey(r.key)},
+		lon, lat, radius, 2*limit, limit, cutoff, metaKey(""), lockKey("")).Slice()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("nearby: %w", err)
 	}
 	out := make([]models.Driver, 0, len(res))
-	for _, g := range res {
-		d := models.Driver{ID: g.Name}
-		// go-redis GeoLocation exposes Latitude and Longitude
-		d.Loc.Lat = g.Latitude
-		d.Loc.Lon = g.Longitude
-		// try to fetch metadata
-		if m, err := r.client.HGetAll(r.ctx, metaKey(g.Name)).Result(); err == nil {
-			if v, ok := m["rating"]; ok {
-				if f, err := strconv.ParseFloat(v, 64); err == nil {
-					d.Rating = f
-				}
-			}
-			if v, ok := m["online"]; ok {
-				d.Online = (v == "true")
-			}
+	for _, row := range res {
+		d, err := parseNearbyRow(row)
+		if err != nil {
+			return nil, err
 		}
 		out = append(out, d)
 	}
-	return out
+	return out, nil
+}
+
+// nearbyFields are the metadata fields nearbyScript returns after the
+// id, lon, lat and last-seen of each row.
+var nearbyFields = []string{"rating", "updated", "status", "city", "heading", "speed", "accuracy", "recorded_at"}
+
+// parseNearbyRow decodes one nearbyScript row.
+func parseNearbyRow(row interface{}) (models.Driver, error) {
+	f, ok := row.([]interface{})
+	if !ok || len(f) != 4+len(nearbyFields) {
+		return models.Driver{}, fmt.Errorf("nearby: unexpected row %v", row)
+	}
+	str := make([]string, len(f))
+	for i, v := range f {
+		str[i], _ = v.(string)
+	}
+	m := make(map[string]string, len(nearbyFields))
+	for i, name := range nearbyFields {
+		m[name] = str[4+i]
+	}
+	d := models.Driver{ID: str[0], Online: true, Status: models.DriverStatus(m["status"]), City: m["city"]}
+	var err error
+	if d.Loc.Lon, err = strconv.ParseFloat(str[1], 64); err != nil {
+		return models.Driver{}, fmt.Errorf("nearby: longitude of %s: %w", d.ID, err)
+	}
+	if d.Loc.Lat, err = strconv.ParseFloat(str[2], 64); err != nil {
+		return models.Driver{}, fmt.Errorf("nearby: latitude of %s: %w", d.ID, err)
+	}
+	// metadata written by older versions may lack fields; leave them zero
+	d.Rating, _ = strconv.ParseFloat(m["rating"], 64)
+	if h, err := strconv.ParseFloat(m["heading"], 64); err == nil {
+		d.Heading = &h
+	}
+	d.Speed, _ = strconv.ParseFloat(m["speed"], 64)
+	d.Accuracy, _ = strconv.ParseFloat(m["accuracy"], 64)
+	d.RecordedAt, _ = time.Parse(time.RFC3339Nano, m["recorded_at"])
+	if t, err := time.Parse(time.RFC3339Nano, m["updated"]); err == nil {
+		d.Updated = t
+	} else if ms, err := strconv.ParseFloat(str[3], 64); err == nil {
+		d.Updated = time.UnixMilli(int64(ms))
+	}
+	return d, nil
 }
 
 func metaKey(id string) string { return "driver:meta:" + id }
+
+// SeenKey is the sorted set of last-seen unix milliseconds for the drivers
+// in the GEO set geoKey. Every writer of the GEO set must update it too.
+func SeenKey(geoKey string) string { return geoKey + ":seen" }
+
+// OnlineKey is the hash of online driver IDs to their city for the GEO set
+// geoKey.
+func OnlineKey(geoKey string) string { return geoKey + ":online" }
+
+func lockKey(id string) string { return "driver:lock:" + id }
@@ -0,0 +1,192 @@
+package geo
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// SchemaVersion is the encoding of the driver metadata hashes, stored in
+// their "schema" field. Every value is a string: numbers in Go's shortest
+// decimal form, booleans as "true"/"false" and times as RFC 3339. Hashes
+// without the field were written by older consumers, which stored online
+// as "1"/"0"; readers still accept that.
+const SchemaVersion = 1
+
+// applyLocationScript records a location ping for driver ARGV[1].
+//
+// Pings carrying a device time or sequence number (ARGV[5], ARGV[6]) are
+// only applied when newer than the last applied one; others always are.
+// The script then moves the driver in the GEO set, stamps the last-seen set
+// with ARGV[4] (unix ms) and writes the metadata pairs from ARGV[9] on. An
+// explicit status (ARGV[7]) sets the driver's availability; without one a
+// driver keeps its "online" field and a new driver takes ARGV[8]. The
+// online hash (id -> city) follows the result. Returns {applied, online} as
+// 0/1 integers; a dropped ping reports the driver's stored availability.
+var applyLocationScript = redis.NewScript(`
+local function isOnline(v) return v == 'true' or v == '1' end
+local rec = tonumber(ARGV[5])
+local seq = tonumber(ARGV[6])
+if rec > 0 or seq > 0 then
+  local cur = redis.call('HMGET', KEYS[3], 'recorded_ms', 'seq')
+  local curRec = tonumber(cur[1]) or 0
+  local curSeq = tonumber(cur[2]) or 0
+  if rec < curRec or (rec == curRec and seq <= curSeq) then
+    return {0, isOnline(redis.call('HGET', KEYS[3], 'online')) and 1 or 0}
+  end
+  redis.call('HSET', KEYS[3], 'recorded_ms', ARGV[5], 'seq', ARGV[6])
+end
+redis.call('GEOADD', KEYS[1], ARGV[2], ARGV[3], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[1])
+redis.call('HSET', KEYS[3], unpack(ARGV, 9))
+local online
+if ARGV[7] ~= '' then
+  online = tostring(ARGV[7] == 'online')
+  redis.call('HSET', KEYS[3], 'status', ARGV[7], 'online', online)
+else
+  online = redis.call('HGET', KEYS[3], 'online')
+  if not online then
+    online = ARGV[8]
+    redis.call('HSET', KEYS[3], 'online', online)
+  end
+end
+if isOnline(online) then
+  redis.call('HSET', KEYS[4], ARGV[1], redis.call('HGET', KEYS[3], 'city') or '')
+  return {1, 1}
+end
+redis.call('HDEL', KEYS[4], ARGV[1])
+return {1, 0}
+`)
+
+// statusScript sets a driver's availability. Drivers going offline or on a
+// break leave the GEO, last-seen and online sets straight away. A driver
+// going online only joins the online hash once it has a current location,
+// i.e. an entry in the last-seen set; otherwise its next ping adds it.
+var statusScript = redis.NewScript(`
+local online = tostring(ARGV[2] == 'online')
+redis.call('HSET', KEYS[3], 'status', ARGV[2], 'online', online, 'schema', ARGV[4])
+if ARGV[3] ~= '' then
+  redis.call('HSET', KEYS[3], 'city', ARGV[3])
+end
+if online == 'true' then
+  if redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+    redis.call('HSET', KEYS[4], ARGV[1], redis.call('HGET', KEYS[3], 'city') or '')
+  end
+else
+  redis.call('ZREM', KEYS[1], ARGV[1])
+  redis.call('ZREM', KEYS[2], ARGV[1])
+  redis.call('HDEL', KEYS[4], ARGV[1])
+end
+return 1
+`)
+
+// DriverStateWriter writes driver locations and availability to Redis in
+// the layout RedisGeo reads: the GEO set, its last-seen set (SeenKey), the
+// online hash (OnlineKey) and one metadata hash per driver. RedisGeo and
+// the location consumer both write through it.
+type DriverStateWriter struct {
+	client *redis.Client
+	key    string
+}
+
+// NewDriverStateWriter writes to the GEO set geoKey and its companion keys.
+func NewDriverStateWriter(client *redis.Client, geoKey string) *DriverStateWriter {
+	return &DriverStateWriter{client: client, key: geoKey}
+}
+
+// ApplyLocation records a ping received at now. It reports false without
+// writing anything when a newer or identical ping was already applied.
+func (w *DriverStateWriter) ApplyLocation(ctx context.Context, d *models.Driver, now time.Time) (bool, error) {
+	applied, _, err := w.applyLocation(ctx, d, now)
+	return applied, err
+}
+
+// applyLocation is ApplyLocation that also reports whether the driver is
+// online once the ping was handled.
+func (w *DriverStateWriter) applyLocation(ctx context.Context, d *models.Driver, now time.Time) (applied, online bool, err error) {
+	keys, args := w.locationArgs(d, now)
+	res, err := applyLocationScript.Run(ctx, w.client, keys, args...).Int64Slice()
+	if err != nil {
+		return false, false, err
+	}
+	return res[0] == 1, res[1] == 1, nil
+}
+
+// ApplyLocations applies the pings in order in one pipeline and reports
+// ApplyLocation's result for each of them.
+func (w *DriverStateWriter) ApplyLocations(ctx context.Context, ds []*models.Driver, now time.Time) ([]bool, []error) {
+	exec := func() []*redis.Cmd {
+		pipe := w.client.Pipeline()
+		cmds := make([]*redis.Cmd, len(ds))
+		for i, d := range ds {
+			keys, args := w.locationArgs(d, now)
+			cmds[i] = applyLocationScript.EvalSha(ctx, pipe, keys, args...)
+		}
+		_, _ = pipe.Exec(ctx)
+		return cmds
+	}
+	cmds := exec()
+	// EVALSHA cannot fall back to EVAL inside a pipeline, so load the
+	// script once and go again if Redis does not know it yet
+	if len(cmds) > 0 && redis.HasErrorPrefix(cmds[0].Err(), "NOSCRIPT") {
+		if err := applyLocationScript.Load(ctx, w.client).Err(); err == nil {
+			cmds = exec()
+		}
+	}
+	applied := make([]bool, len(ds))
+	errs := make([]error, len(ds))
+	for i, c := range cmds {
+		res, err := c.Int64Slice()
+		applied[i], errs[i] = err == nil && res[0] == 1, err
+	}
+	return applied, errs
+}
+
+// SetStatus changes a driver's availability.
+func (w *DriverStateWriter) SetStatus(ctx context.Context, driverID string, status models.DriverStatus, city string) error {
+	return statusScript.Run(ctx, w.client, w.keys(driverID), driverID, string(status), city, SchemaVersion).Err()
+}
+
+// keys are the keys both scripts touch for one driver.
+func (w *DriverStateWriter) keys(id string) []string {
+	return []string{w.key, SeenKey(w.key), metaKey(id), OnlineKey(w.key)}
+}
+
+// locationArgs builds the keys and arguments of applyLocationScript.
+func (w *DriverStateWriter) locationArgs(d *models.Driver, now time.Time) ([]string, []interface{}) {
+	var recordedMs int64
+	recordedAt := "" // cleared when the device sent no timestamp, so an old one never lingers
+	if !d.RecordedAt.IsZero() {
+		recordedMs = d.RecordedAt.UnixMilli()
+		recordedAt = d.RecordedAt.Format(time.RFC3339Nano)
+	}
+	args := []interface{}{d.ID, d.Loc.Lon, d.Loc.Lat, now.UnixMilli(), recordedMs, d.Seq, string(d.Status), strconv.FormatBool(d.Online),
+		"schema", SchemaVersion,
+		"updated", now.Format(time.RFC3339Nano),
+		"heading", formatHeading(d.Heading),
+		"speed", formatFloat(d.Speed),
+		"accuracy", formatFloat(d.Accuracy),
+		"recorded_at", recordedAt,
+	}
+	if d.City != "" {
+		args = append(args, "city", d.City)
+	}
+	// pings without a rating, such as telemetry frames, keep the stored one
+	if d.Rating > 0 {
+		args = append(args, "rating", formatFloat(d.Rating))
+	}
+	return w.keys(d.ID), args
+}
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+// formatHeading stores an unknown heading as "", so it is read back as nil.
+func formatHeading(h *float64) string {
+	if h == nil {
+		return ""
+	}
+	return formatFloat(*h)
+}
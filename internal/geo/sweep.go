@@ -0,0 +1,36 @@
+package geo
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/example/ride-matching/internal/observability"
+)
+
+// Sweeper evicts drivers that stopped sending location updates. Index and
+// RedisGeo implement it.
+type Sweeper interface {
+	Sweep(ctx context.Context) (int, error)
+}
+
+// RunSweeper calls Sweep every interval until ctx is canceled and counts
+// evictions in observability.DriversEvicted.
+func RunSweeper(ctx context.Context, s Sweeper, interval time.Duration, logger *slog.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := s.Sweep(ctx)
+			observability.DriversEvicted.Add(float64(n))
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("driver sweep failed", "error", err)
+			} else if n > 0 {
+				logger.Debug("evicted stale drivers", "count", n)
+			}
+		}
+	}
+}
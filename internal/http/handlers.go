@@ -1,23 +1,36 @@
 package httpapi
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/example/ride-matching/internal/config"
 	"github.com/example/ride-matching/internal/dispatch"
+	"github.com/example/ride-matching/internal/eta"
+	"github.com/example/ride-matching/internal/events"
 	"github.com/example/ride-matching/internal/geo"
 	"github.com/example/ride-matching/internal/ingest"
 	"github.com/example/ride-matching/internal/matcher"
 	"github.com/example/ride-matching/internal/models"
-	"github.com/example/ride-matching/internal/observability"
+	"github.com/example/ride-matching/internal/payments"
+	"github.com/example/ride-matching/internal/pb"
+	"github.com/example/ride-matching/internal/pricing"
+	"github.com/example/ride-matching/internal/rides"
 	"github.com/example/ride-matching/internal/storage"
 )
 
@@ -25,20 +38,28 @@ type Server struct {
 	cfg    config.ServerConfig
 	logger *slog.Logger
 
-	Geo     geo.Geo
-	Matcher *matcher.Service
-	Store   storage.TripStore
-	Kafka   *ingest.KafkaProducer
-	WSReg   *dispatch.WSRegistry
-	mux     *mux.Router
+	Geo      geo.Geo
+	Matcher  *matcher.Service
+	Rides    *rides.Service
+	Store    storage.TripStore
+	Surge    *pricing.Surge
+	Quotes   *pricing.Quoter
+	Payments payments.Provider
+	Kafka    *ingest.KafkaProducer
+	WSReg    *dispatch.WSRegistry
+	mux      *mux.Router
 }
 
 func NewServer(cfg config.ServerConfig, logger *slog.Logger) (*Server, error) {
 	var ggeo geo.Geo
 	if cfg.RedisAddr != "" {
-		ggeo = geo.NewRedisGeo(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisGeoKey)
+		rg := geo.NewRedisGeo(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.RedisGeoKey)
+		rg.MaxAge = cfg.DriverMaxAge
+		ggeo = rg
 	} else {
-		ggeo = geo.NewIndex()
+		idx := geo.NewIndex()
+		idx.MaxAge = cfg.DriverMaxAge
+		ggeo = idx
 	}
 
 	var store storage.TripStore
@@ -55,32 +76,134 @@ func NewServer(cfg config.ServerConfig, logger *slog.Logger) (*Server, error) {
 
 	var kp *ingest.KafkaProducer
 	if len(cfg.KafkaBrokers) > 0 {
-		kp = ingest.NewKafkaProducer(cfg.KafkaBrokers, cfg.KafkaTopic)
+		pc, err := ingest.NewProducerConfig(cfg.KafkaBrokers, cfg.KafkaTopic, ingest.Encoding(cfg.KafkaEncoding), cfg.KafkaProducer)
+		if err != nil {
+			return nil, err
+		}
+		kp = ingest.NewKafkaProducer(pc)
 	}
 
 	wsreg := dispatch.NewWSRegistry()
+	surge := pricing.NewSurge(pricing.SurgeConfig{
+		CellPrecision: cfg.SurgeCellPrecision,
+		MaxMultiplier: cfg.SurgeMaxMultiplier,
+		Sensitivity:   cfg.SurgeSensitivity,
+		Smoothing:     cfg.SurgeSmoothing,
+	})
+
+	fares := pricing.DefaultFares()
+	if cfg.PricingFares != "" {
+		t, err := pricing.ParseFareTable(cfg.PricingFares)
+		if err != nil {
+			return nil, fmt.Errorf("PRICING_FARES: %w", err)
+		}
+		fares = t
+	}
+	var etaClient eta.Client
+	if cfg.OSRMURL != "" {
+		etaClient = eta.NewOSRMClient(cfg.OSRMURL)
+	}
+	quoteKey := []byte(cfg.QuoteSigningKey)
+	if len(quoteKey) == 0 {
+		quoteKey = make([]byte, 32)
+		_, _ = rand.Read(quoteKey)
+		logger.Warn("QUOTE_SIGNING_KEY not set; quotes are only valid on this replica")
+	}
+	quoter := &pricing.Quoter{Fares: fares, ETA: etaClient, SpeedMps: cfg.DefaultSpeedMps, Surge: surge, Key: quoteKey, TTL: cfg.QuoteTTL}
 
-	m := &matcher.Service{Geo: ggeo, Dispatch: wsreg, Store: store, DefaultSpeedMps: cfg.DefaultSpeedMps, TopN: cfg.MatcherTopN}
+	var pay payments.Provider
+	if cfg.StripeAPIKey != "" {
+		pay = payments.NewStripeClient(cfg.StripeAPIKey)
+	} else {
+		pay = payments.NewFake()
+		logger.Warn("STRIPE_API_KEY not set; using in-memory fake payments")
+	}
+
+	rs := &rides.Service{Store: store, Geo: ggeo, Payments: pay}
+	m := &matcher.Service{Geo: ggeo, Dispatch: wsreg, Store: store, Rides: rs, Surge: surge, DefaultSpeedMps: cfg.DefaultSpeedMps, TopN: cfg.MatcherTopN, OfferTimeout: cfg.MatcherOfferTimeout, TripHold: cfg.MatcherTripHold, HeadingPenalty: cfg.MatcherHeadingPenalty}
+	m.Search = geo.SearchOptions{Radius: cfg.SearchRadius, MaxRadius: cfg.SearchMaxRadius, Step: cfg.SearchStep, MinCandidates: cfg.MinCandidates}
+	if etaClient != nil {
+		m.ETAClient = etaClient
+		m.ETACache = eta.NewCache(time.Minute)
+	}
+	if cfg.MatcherMode == "batch" {
+		m.BatchWindow = cfg.MatcherBatchWindow
+	}
+	rs.Offers = m
 
 	router := mux.NewRouter()
 	s := &Server{
-		cfg:     cfg,
-		logger:  logger,
-		Geo:     ggeo,
-		Matcher: m,
-		Store:   store,
-		Kafka:   kp,
-		WSReg:   wsreg,
-		mux:     router,
+		cfg:      cfg,
+		logger:   logger,
+		Geo:      ggeo,
+		Matcher:  m,
+		Rides:    rs,
+		Store:    store,
+		Surge:    surge,
+		Quotes:   quoter,
+		Payments: pay,
+		Kafka:    kp,
+		WSReg:    wsreg,
+		mux:      router,
 	}
 	s.routes()
 	s.registerMiddleware()
 	return s, nil
 }
 
+// Start launches the server's background workers; they stop when ctx is
+// canceled.
+func (s *Server) Start(ctx context.Context) {
+	go s.Surge.Run(ctx, s.cfg.SurgeInterval)
+	if sw, ok := s.Geo.(geo.Sweeper); ok {
+		go geo.RunSweeper(ctx, sw, s.cfg.DriverSweepInterval, s.logger)
+	}
+	if oc, ok := s.Geo.(geo.OnlineCounter); ok {
+		go geo.RunOnlineGauge(ctx, oc, s.cfg.OnlineGaugeInterval, s.logger)
+	}
+	if outbox, ok := s.Store.(storage.Outbox); ok && len(s.cfg.KafkaBrokers) > 0 {
+		pub := ingest.NewKafkaEventPublisher(s.cfg.KafkaBrokers, s.cfg.KafkaRideEventsTopic)
+		pub.Encoding = ingest.Encoding(s.cfg.KafkaEncoding)
+		relay := &events.Relay{Outbox: outbox, Publisher: pub, Interval: s.cfg.OutboxPollInterval, Logger: s.logger}
+		go func() {
+			relay.Run(ctx)
+			_ = pub.Close()
+		}()
+	}
+}
+
+// Shutdown flushes the location messages still queued for Kafka. Call it
+// once the HTTP server has stopped taking requests.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.Kafka == nil {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Kafka.Close() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("flush kafka producer: %w", ctx.Err())
+	}
+}
+
 func (s *Server) routes() {
 	s.mux.HandleFunc("/internal/driver/locations", s.handleDriverLocation).Methods("POST")
+	s.mux.HandleFunc("/internal/driver/locations/batch", s.handleDriverLocationBatch).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/quote", s.handleRideQuote).Methods("POST")
 	s.mux.HandleFunc("/api/v1/rides/request", s.handleRideRequest).Methods("POST")
+	s.mux.HandleFunc("/api/v1/surge", s.handleSurge).Methods("GET")
+	s.mux.HandleFunc("/api/v1/rides/{id}/decision", s.handleOfferDecision).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/{id}/accept", s.handleRideAccept).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/{id}/arrive", s.handleRideStep(s.Rides.Arrive)).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/{id}/start", s.handleRideStep(s.Rides.Start)).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/{id}/complete", s.handleRideStep(s.Rides.Complete)).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/{id}/cancel", s.handleRideCancel).Methods("POST")
+	s.mux.HandleFunc("/api/v1/rides/{id}", s.handleGetRide).Methods("GET")
+	s.mux.HandleFunc("/api/v1/riders/{id}/rides", s.handleRideHistory(s.Store.ListRidesByRider)).Methods("GET")
+	s.mux.HandleFunc("/api/v1/drivers/{id}/status", s.handleDriverStatus).Methods("POST")
+	s.mux.HandleFunc("/api/v1/drivers/{id}/rides", s.handleRideHistory(s.Store.ListRidesByDriver)).Methods("GET")
 	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("ok")) }).Methods("GET")
 	s.mux.Handle("/metrics", promhttp.Handler())
 	s.mux.HandleFunc("/ws/{driver_id}", s.handleWS)
@@ -94,18 +217,140 @@ func (s *Server) handleDriverLocation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	d.Online = true
-	// publish to kafka if configured
+	if err := s.ingestLocation(r.Context(), d); err != nil {
+		http.Error(w, "location stream unavailable", 503)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// errLocationStream is returned by ingestLocation when Kafka did not take
+// the ping.
+var errLocationStream = errors.New("location stream unavailable")
+
+// ingestLocation publishes a location ping to Kafka, if configured, and
+// applies it to the geo store and surge pricing, which counts the driver as
+// supply only while the geo store has it online. A ping Kafka did not take
+// is not applied.
+func (s *Server) ingestLocation(ctx context.Context, d models.Driver) error {
+	// availability only changes through the status endpoint
+	d.Status, d.Online = "", false
 	if s.Kafka != nil {
-		_ = s.Kafka.PublishLocation(d)
+		if err := s.Kafka.PublishLocation(ctx, d); err != nil {
+			s.logger.Warn("publish location", "driver_id", d.ID, "error", err)
+			return errLocationStream
+		}
+	}
+	d.Online = s.Geo.Upsert(d)
+	s.Surge.ObserveDriver(d)
+	return nil
+}
+
+// maxPingBytes bounds the request body of a batch to this many bytes per
+// allowed ping.
+const maxPingBytes = 1 << 10
+
+type locationBatchRequest struct {
+	Pings []models.Driver `json:"pings"`
+}
+
+type locationResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // "accepted" or "rejected"
+	Error  string `json:"error,omitempty"`
+}
+
+// handleDriverLocationBatch accepts up to IngestMaxBatch pings buffered by a
+// phone, as JSON or as a protobuf pb.LocationBatch (Content-Type
+// application/x-protobuf). Each ping is validated on its own and the valid
+// ones are published to Kafka in one write before being applied in order.
+// The response carries a result per ping; a failed publish applies none of
+// them and returns 503 so the phone keeps its buffer.
+func (s *Server) handleDriverLocationBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.cfg.IngestMaxBatch)*maxPingBytes)
+	var pings []models.Driver
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-protobuf" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		var batch pb.LocationBatch
+		if err := proto.Unmarshal(body, &batch); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		for _, p := range batch.GetPings() {
+			pings = append(pings, ingest.DriverFromProto(p))
+		}
+	} else {
+		var req locationBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		pings = req.Pings
 	}
-	// update geo store
-	if up, ok := s.Geo.(interface{ Upsert(models.Driver) }); ok {
-		up.Upsert(d)
+	if len(pings) > s.cfg.IngestMaxBatch {
+		http.Error(w, fmt.Sprintf("at most %d pings per batch", s.cfg.IngestMaxBatch), 400)
+		return
 	}
-	// update metrics
-	observability.DriversOnline.Inc()
-	w.WriteHeader(204)
+
+	now := time.Now()
+	results := make([]locationResult, len(pings))
+	var valid []models.Driver
+	for i, d := range pings {
+		results[i] = locationResult{Index: i, Status: "accepted"}
+		if err := ingest.ValidatePing(d, now); err != nil {
+			results[i] = locationResult{Index: i, Status: "rejected", Error: err.Error()}
+			continue
+		}
+		// availability only changes through the status endpoint
+		d.Status, d.Online = "", false
+		valid = append(valid, d)
+	}
+	if s.Kafka != nil && len(valid) > 0 {
+		if err := s.Kafka.PublishLocations(r.Context(), valid); err != nil {
+			s.logger.Error("publish location batch", "pings", len(valid), "error", err)
+			http.Error(w, "location stream unavailable", 503)
+			return
+		}
+	}
+	for _, d := range valid {
+		d.Online = s.Geo.Upsert(d)
+		s.Surge.ObserveDriver(d)
+	}
+	writeJSON(w, 200, map[string]any{"accepted": len(valid), "results": results})
+}
+
+type driverStatusRequest struct {
+	Status models.DriverStatus `json:"status"`
+	City   string              `json:"city,omitempty"`
+}
+
+// handleDriverStatus takes a driver online, offline or on a break. Offline
+// drivers and drivers on a break are no longer returned by searches.
+func (s *Server) handleDriverStatus(w http.ResponseWriter, r *http.Request) {
+	var req driverStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	switch req.Status {
+	case models.DriverOnline, models.DriverOffline, models.DriverBreak:
+	default:
+		http.Error(w, "status must be online, offline or break", 400)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := s.Geo.SetStatus(id, req.Status, req.City); err != nil {
+		s.logger.Error("set driver status", "driver_id", id, "error", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	s.Surge.ObserveDriver(models.Driver{ID: id, Status: req.Status, City: req.City})
+	writeJSON(w, 200, map[string]any{"driver_id": id, "status": req.Status})
 }
 
 func (s *Server) handleRideRequest(w http.ResponseWriter, r *http.Request) {
@@ -114,16 +359,239 @@ func (s *Server) handleRideRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+	if rr.QuoteID != "" {
+		q, err := s.Quotes.Verify(rr.QuoteID)
+		switch {
+		case errors.Is(err, pricing.ErrQuoteExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), 400)
+			return
+		case q.RiderID != rr.RiderID || q.Origin != rr.Origin || q.Destination != rr.Destination:
+			http.Error(w, "quote does not match ride request", 400)
+			return
+		}
+		rr.Fare = q.Fare()
+	} else {
+		rr.Fare = s.Quotes.Estimate(rr).Fare()
+	}
 	rideID := newID()
-	offer, ok := s.Matcher.Match(rideID, rr)
+	intentID, err := s.Payments.Hold(r.Context(), rideID, rr.Fare.Amount, rr.Fare.Currency, rr.CustomerID, rr.PaymentMethodID)
+	if err != nil {
+		s.logger.Warn("payment hold failed", "ride_id", rideID, "error", err)
+		http.Error(w, "payment authorisation failed", http.StatusPaymentRequired)
+		return
+	}
+	rr.PaymentIntentID = intentID
+	if _, err := s.Matcher.Match(rideID, rr); err != nil {
+		s.releaseHold(r.Context(), rideID, intentID)
+		if errors.Is(err, matcher.ErrNoDrivers) {
+			http.Error(w, "no drivers available", 503)
+			return
+		}
+		s.logger.Error("driver search failed", "ride_id", rideID, "error", err)
+		http.Error(w, "driver search unavailable", http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"ride_id": rideID, "status": models.StatusRequested, "fare": rr.Fare})
+}
+
+// releaseHold cancels the payment hold of a ride request that could not be
+// matched. Once the matcher has saved the ride, the system cancel that ends
+// an exhausted offer cascade has already settled the hold, so it is only
+// released here when it is still held.
+func (s *Server) releaseHold(ctx context.Context, rideID, intentID string) {
+	if ride, err := s.Store.GetRide(rideID); err == nil && ride.PaymentStatus != models.PaymentHeld {
+		return
+	}
+	if err := s.Payments.Cancel(ctx, intentID); err != nil {
+		s.logger.Error("release payment hold failed", "ride_id", rideID, "error", err)
+	}
+}
+
+// handleRideQuote prices a trip upfront. The returned quote_id can be passed
+// to /api/v1/rides/request before expires_at to lock the price.
+func (s *Server) handleRideQuote(w http.ResponseWriter, r *http.Request) {
+	var rr models.RideRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	q, err := s.Quotes.Quote(rr)
+	if err != nil {
+		s.logger.Error("quote failed", "error", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	writeJSON(w, 200, q)
+}
+
+func (s *Server) handleSurge(w http.ResponseWriter, r *http.Request) {
+	lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		http.Error(w, "lat and lon query parameters are required", 400)
+		return
+	}
+	cell, m := s.Surge.Lookup(lat, lon)
+	writeJSON(w, 200, map[string]any{"cell": cell, "multiplier": m})
+}
+
+// handleOfferDecision lets a driver accept or decline an offer over HTTP; the
+// same decision can also be sent as a JSON frame on the driver WebSocket.
+func (s *Server) handleOfferDecision(w http.ResponseWriter, r *http.Request) {
+	var dec models.MatchDecision
+	if err := json.NewDecoder(r.Body).Decode(&dec); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	dec.RideID = mux.Vars(r)["id"]
+	if dec.DriverID == "" {
+		http.Error(w, "driver_id is required", 400)
+		return
+	}
+	if err := s.Matcher.Decide(dec); err != nil {
+		s.writeRideError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// rideActionRequest identifies who is acting on a ride. Driver endpoints
+// need driver_id; cancel takes either rider_id or driver_id.
+type rideActionRequest struct {
+	RiderID  string `json:"rider_id"`
+	DriverID string `json:"driver_id"`
+}
+
+func decodeRideAction(w http.ResponseWriter, r *http.Request) (rideActionRequest, bool) {
+	var req rideActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return req, false
+	}
+	return req, true
+}
+
+// handleRideAccept is the driver accepting the offer it currently holds.
+func (s *Server) handleRideAccept(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRideAction(w, r)
 	if !ok {
-		http.Error(w, "no drivers available", 503)
 		return
 	}
-	observability.MatchesTotal.Inc()
-	resp := map[string]any{"ride_id": rideID, "offer": offer}
+	if req.DriverID == "" {
+		http.Error(w, "driver_id is required", 400)
+		return
+	}
+	rideID := mux.Vars(r)["id"]
+	if err := s.Matcher.Decide(models.MatchDecision{RideID: rideID, DriverID: req.DriverID, Accepted: true}); err != nil {
+		s.writeRideError(w, err)
+		return
+	}
+	ride, err := s.Store.GetRide(rideID)
+	if err != nil {
+		s.writeRideError(w, err)
+		return
+	}
+	writeJSON(w, 200, ride)
+}
+
+// handleRideStep serves the driver-driven transitions (arrive, start,
+// complete).
+func (s *Server) handleRideStep(step func(rideID, driverID string) (*models.Ride, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRideAction(w, r)
+		if !ok {
+			return
+		}
+		if req.DriverID == "" {
+			http.Error(w, "driver_id is required", 400)
+			return
+		}
+		ride, err := step(mux.Vars(r)["id"], req.DriverID)
+		if err != nil {
+			s.writeRideError(w, err)
+			return
+		}
+		writeJSON(w, 200, ride)
+	}
+}
+
+func (s *Server) handleRideCancel(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRideAction(w, r)
+	if !ok {
+		return
+	}
+	var by, actor string
+	switch {
+	case req.RiderID != "":
+		by, actor = models.CanceledByRider, req.RiderID
+	case req.DriverID != "":
+		by, actor = models.CanceledByDriver, req.DriverID
+	default:
+		http.Error(w, "rider_id or driver_id is required", 400)
+		return
+	}
+	ride, err := s.Rides.Cancel(mux.Vars(r)["id"], by, actor)
+	if err != nil {
+		s.writeRideError(w, err)
+		return
+	}
+	writeJSON(w, 200, ride)
+}
+
+func (s *Server) handleGetRide(w http.ResponseWriter, r *http.Request) {
+	ride, err := s.Store.GetRide(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeRideError(w, err)
+		return
+	}
+	writeJSON(w, 200, ride)
+}
+
+// handleRideHistory pages through a rider's or driver's rides, newest first,
+// using ?limit= and the opaque ?cursor= returned as next_cursor.
+func (s *Server) handleRideHistory(list func(id string, page storage.PageRequest) (storage.Page, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := storage.PageRequest{Cursor: r.URL.Query().Get("cursor")}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive integer", 400)
+				return
+			}
+			page.Limit = n
+		}
+		res, err := list(mux.Vars(r)["id"], page)
+		if err != nil {
+			s.writeRideError(w, err)
+			return
+		}
+		writeJSON(w, 200, res)
+	}
+}
+
+func (s *Server) writeRideError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidCursor):
+		http.Error(w, err.Error(), 400)
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, matcher.ErrNoPendingOffer):
+		http.Error(w, err.Error(), 404)
+	case errors.Is(err, rides.ErrNotParticipant):
+		http.Error(w, err.Error(), 403)
+	case errors.Is(err, rides.ErrIllegalTransition), errors.Is(err, matcher.ErrNotOffered), errors.Is(err, matcher.ErrDriverUnavailable):
+		http.Error(w, err.Error(), 409)
+	default:
+		s.logger.Error("ride update failed", "error", err)
+		http.Error(w, "internal error", 500)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
 var upgrader = websocket.Upgrader{}
@@ -136,7 +604,38 @@ func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "upgrade failed", 400)
 		return
 	}
-	s.WSReg.Add(id, conn)
+	s.WSReg.Serve(r.Context(), id, conn, s.handleWSMessage)
+}
+
+// handleWSMessage applies a frame a driver sent over its WebSocket session.
+func (s *Server) handleWSMessage(ctx context.Context, driverID string, m dispatch.Message) error {
+	switch m.Type {
+	case dispatch.MsgOfferAck:
+		s.logger.Debug("ws offer delivered", "driver_id", driverID, "ride_id", m.RideID)
+		return nil
+	case dispatch.MsgAccept, dispatch.MsgDecline:
+		if m.RideID == "" {
+			return errors.New("ride_id is required")
+		}
+		dec := models.MatchDecision{RideID: m.RideID, DriverID: driverID, Accepted: m.Type == dispatch.MsgAccept}
+		if err := s.Matcher.Decide(dec); err != nil {
+			s.logger.Warn("ws offer decision rejected", "driver_id", driverID, "ride_id", m.RideID, "error", err)
+			return err
+		}
+		return nil
+	case dispatch.MsgLocation:
+		if m.Location == nil {
+			return errors.New("location is required")
+		}
+		d := *m.Location
+		d.ID = driverID
+		if err := ingest.ValidatePing(d, time.Now()); err != nil {
+			return err
+		}
+		return s.ingestLocation(ctx, d)
+	default:
+		return fmt.Errorf("unexpected message type %q", m.Type)
+	}
 }
 
 func newID() string { b := make([]byte, 8); _, _ = rand.Read(b); return hex.EncodeToString(b) }
@@ -0,0 +1,275 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/config"
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/ingest"
+	"github.com/example/ride-matching/internal/matcher"
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/payments"
+	"github.com/example/ride-matching/internal/pb"
+	"github.com/example/ride-matching/internal/pricing"
+	"github.com/example/ride-matching/internal/rides"
+	"github.com/example/ride-matching/internal/storage"
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/proto"
+)
+
+type failingDispatch struct{}
+
+func (failingDispatch) Offer(rideID string, offer models.MatchOffer) error {
+	return errors.New("driver unreachable")
+}
+
+type okDispatch struct{}
+
+func (okDispatch) Offer(rideID string, offer models.MatchOffer) error { return nil }
+
+func newTestServer(logs *bytes.Buffer) (*Server, *geo.Index, *payments.Fake) {
+	idx := geo.NewIndex()
+	store := storage.NewMemoryStore()
+	pay := payments.NewFake()
+	rs := &rides.Service{Store: store, Geo: idx, Payments: pay}
+	m := &matcher.Service{Geo: idx, Dispatch: failingDispatch{}, Store: store, Rides: rs, DefaultSpeedMps: 10, TopN: 5}
+	rs.Offers = m
+	s := &Server{
+		cfg:      config.ServerConfig{IngestMaxBatch: 3},
+		logger:   slog.New(slog.NewTextHandler(logs, nil)),
+		Geo:      idx,
+		Matcher:  m,
+		Rides:    rs,
+		Store:    store,
+		Surge:    pricing.NewSurge(pricing.SurgeConfig{}),
+		Quotes:   &pricing.Quoter{Fares: pricing.DefaultFares(), SpeedMps: 10},
+		Payments: pay,
+		mux:      mux.NewRouter(),
+	}
+	s.routes()
+	return s, idx, pay
+}
+
+func TestUnmatchedRideReleasesHoldOnce(t *testing.T) {
+	body := `{"rider_id":"r1","origin":{"lat":0,"lon":0},"destination":{"lat":0.05,"lon":0.05}}`
+	for _, c := range []struct {
+		name    string
+		drivers []models.Driver
+	}{
+		{"no candidates", nil},
+		// the ride is saved, every offer fails and the matcher cancels it
+		{"every dispatch fails", []models.Driver{{ID: "d1", Loc: models.Coord{Lat: 0.001, Lon: 0}, Rating: 5, Online: true}}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			s, idx, pay := newTestServer(&logs)
+			for _, d := range c.drivers {
+				idx.Upsert(d)
+			}
+			rec := httptest.NewRecorder()
+			s.handleRideRequest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/rides/request", strings.NewReader(body)))
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503, got %d: %s", rec.Code, rec.Body)
+			}
+			in, ok := pay.Intent("pi_fake_1")
+			if !ok || in.Status != "canceled" {
+				t.Fatalf("expected hold to be released, got %+v", in)
+			}
+			if strings.Contains(logs.String(), "release payment hold failed") {
+				t.Fatalf("hold released twice:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+func TestRideRequestHoldsOnRidersCard(t *testing.T) {
+	var logs bytes.Buffer
+	s, _, pay := newTestServer(&logs)
+	body := `{"rider_id":"r1","origin":{"lat":0,"lon":0},"destination":{"lat":0.05,"lon":0.05},"customer_id":"cus_1","payment_method_id":"pm_1"}`
+	s.handleRideRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/rides/request", strings.NewReader(body)))
+	in, ok := pay.Intent("pi_fake_1")
+	if !ok || in.CustomerID != "cus_1" || in.PaymentMethodID != "pm_1" {
+		t.Fatalf("expected the hold on the rider's card, got %+v", in)
+	}
+}
+
+// serve sends a request through the server's router.
+func serve(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestRideRoutesMapErrors(t *testing.T) {
+	var logs bytes.Buffer
+	s, idx, _ := newTestServer(&logs)
+	s.Matcher.Dispatch = okDispatch{}
+	idx.Upsert(models.Driver{ID: "d1", Loc: models.Coord{Lat: 0.001, Lon: 0}, Rating: 5, Online: true})
+	if _, err := s.Matcher.Match("offered", models.RideRequest{RiderID: "r1", Destination: models.Coord{Lat: 0.05, Lon: 0.05}}); err != nil {
+		t.Fatal(err)
+	}
+	for id, status := range map[string]models.RideStatus{
+		"accepted": models.StatusAccepted,
+		"arrived":  models.StatusArrived,
+		"ongoing":  models.StatusOngoing,
+		"done":     models.StatusCompleted,
+	} {
+		_ = s.Store.SaveRide(&models.Ride{ID: id, RiderID: "r1", DriverID: "d1", Status: status})
+	}
+	for _, c := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/api/v1/rides/offered/accept", `{"driver_id":"d2"}`, http.StatusConflict},
+		{"/api/v1/rides/missing/accept", `{"driver_id":"d1"}`, http.StatusNotFound},
+		{"/api/v1/rides/done/accept", `{"driver_id":"d1"}`, http.StatusNotFound}, // no pending offer
+		{"/api/v1/rides/accepted/arrive", `{"driver_id":"d2"}`, http.StatusForbidden},
+		{"/api/v1/rides/done/arrive", `{"driver_id":"d1"}`, http.StatusConflict},
+		{"/api/v1/rides/missing/arrive", `{"driver_id":"d1"}`, http.StatusNotFound},
+		{"/api/v1/rides/arrived/start", `{"driver_id":"d2"}`, http.StatusForbidden},
+		{"/api/v1/rides/accepted/start", `{"driver_id":"d1"}`, http.StatusConflict},
+		{"/api/v1/rides/missing/start", `{"driver_id":"d1"}`, http.StatusNotFound},
+		{"/api/v1/rides/ongoing/complete", `{"driver_id":"d2"}`, http.StatusForbidden},
+		{"/api/v1/rides/accepted/complete", `{"driver_id":"d1"}`, http.StatusConflict},
+		{"/api/v1/rides/missing/complete", `{"driver_id":"d1"}`, http.StatusNotFound},
+		{"/api/v1/rides/accepted/cancel", `{"rider_id":"r2"}`, http.StatusForbidden},
+		{"/api/v1/rides/accepted/cancel", `{"driver_id":"d2"}`, http.StatusForbidden},
+		{"/api/v1/rides/done/cancel", `{"rider_id":"r1"}`, http.StatusConflict},
+		{"/api/v1/rides/missing/cancel", `{"rider_id":"r1"}`, http.StatusNotFound},
+	} {
+		if rec := serve(s, http.MethodPost, c.path, c.body); rec.Code != c.want {
+			t.Errorf("POST %s %s = %d, want %d: %s", c.path, c.body, rec.Code, c.want, rec.Body)
+		}
+	}
+	if strings.Contains(logs.String(), "ride update failed") {
+		t.Fatalf("a client error was logged as a failure:\n%s", logs.String())
+	}
+}
+
+type batchResponse struct {
+	Accepted int              `json:"accepted"`
+	Results  []locationResult `json:"results"`
+}
+
+func TestLocationBatch(t *testing.T) {
+	var logs bytes.Buffer
+	s, idx, _ := newTestServer(&logs)
+	for _, id := range []string{"d1", "d2"} {
+		_ = idx.SetStatus(id, models.DriverOnline, "")
+	}
+	nearby := func() int {
+		got, _ := idx.Nearby(0, 0, geo.SearchOptions{Limit: 5})
+		return len(got)
+	}
+
+	// each ping is validated on its own
+	rec := serve(s, http.MethodPost, "/internal/driver/locations/batch",
+		`{"pings":[{"id":"d1","loc":{"lat":0.001,"lon":0}},{"loc":{"lat":0,"lon":0}},{"id":"d2","loc":{"lat":91,"lon":0}}]}`)
+	var res batchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); rec.Code != 200 || err != nil {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+	if res.Accepted != 1 || len(res.Results) != 3 || res.Results[0].Status != "accepted" ||
+		res.Results[1].Status != "rejected" || res.Results[2].Status != "rejected" || res.Results[2].Error == "" {
+		t.Fatalf("unexpected results %+v", res)
+	}
+	if n := nearby(); n != 1 {
+		t.Fatalf("expected the accepted ping to be applied, found %d drivers", n)
+	}
+
+	rec = serve(s, http.MethodPost, "/internal/driver/locations/batch", `{"pings":[{"id":"d1"},{"id":"d1"},{"id":"d1"},{"id":"d1"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 above INGEST_MAX_BATCH, got %d", rec.Code)
+	}
+
+	body, _ := proto.Marshal(&pb.LocationBatch{Pings: []*pb.LocationPing{
+		ingest.DriverToProto(models.Driver{ID: "d1", Loc: models.Coord{Lat: 0.001, Lon: 0}}),
+		ingest.DriverToProto(models.Driver{ID: "d2", Loc: models.Coord{Lat: 0.002, Lon: 0}}),
+	}})
+	req := httptest.NewRequest(http.MethodPost, "/internal/driver/locations/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); rec.Code != 200 || err != nil || res.Accepted != 2 {
+		t.Fatalf("protobuf batch: %d %s", rec.Code, rec.Body)
+	}
+	if n := nearby(); n != 2 {
+		t.Fatalf("expected both protobuf pings to be applied, found %d drivers", n)
+	}
+}
+
+func TestLocationBatchPublishFailureAppliesNothing(t *testing.T) {
+	var logs bytes.Buffer
+	s, idx, _ := newTestServer(&logs)
+	// a queue of one message cannot take the batch, so the publish fails
+	// without reaching a broker
+	s.Kafka = ingest.NewKafkaProducer(ingest.ProducerConfig{Brokers: []string{"127.0.0.1:1"}, Topic: "locations", Async: true, QueueSize: 1})
+	_ = idx.SetStatus("d1", models.DriverOnline, "")
+	_ = idx.SetStatus("d2", models.DriverOnline, "")
+	rec := serve(s, http.MethodPost, "/internal/driver/locations/batch",
+		`{"pings":[{"id":"d1","loc":{"lat":0.001,"lon":0}},{"id":"d2","loc":{"lat":0.002,"lon":0}}]}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", rec.Code, rec.Body)
+	}
+	if got, _ := idx.Nearby(0, 0, geo.SearchOptions{Limit: 5}); len(got) != 0 {
+		t.Fatalf("a failed batch was applied: %+v", got)
+	}
+}
+
+func TestRideReadRoutes(t *testing.T) {
+	var logs bytes.Buffer
+	s, _, _ := newTestServer(&logs)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"ride1", "ride2", "ride3"} {
+		_ = s.Store.SaveRide(&models.Ride{ID: id, RiderID: "r1", DriverID: "d1", Status: models.StatusCompleted, CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+	_ = s.Store.SaveRide(&models.Ride{ID: "other", RiderID: "r2", DriverID: "d2", Status: models.StatusCompleted, CreatedAt: base})
+
+	rec := serve(s, http.MethodGet, "/api/v1/rides/ride2", "")
+	var ride models.Ride
+	if err := json.Unmarshal(rec.Body.Bytes(), &ride); rec.Code != 200 || err != nil || ride.ID != "ride2" {
+		t.Fatalf("get ride: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(s, http.MethodGet, "/api/v1/rides/missing", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing ride, got %d", rec.Code)
+	}
+
+	for _, who := range []string{"riders/r1", "drivers/d1"} {
+		var ids []string
+		path := "/api/v1/" + who + "/rides?limit=2"
+		for pages := 0; path != ""; pages++ {
+			if pages == 3 {
+				t.Fatalf("%s: cursor never ran out", who)
+			}
+			rec := serve(s, http.MethodGet, path, "")
+			var page storage.Page
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); rec.Code != 200 || err != nil {
+				t.Fatalf("%s: %d %s", path, rec.Code, rec.Body)
+			}
+			for _, r := range page.Rides {
+				ids = append(ids, r.ID)
+			}
+			path = ""
+			if page.NextCursor != "" {
+				path = "/api/v1/" + who + "/rides?limit=2&cursor=" + url.QueryEscape(page.NextCursor)
+			}
+		}
+		if strings.Join(ids, ",") != "ride3,ride2,ride1" {
+			t.Fatalf("%s: paged through %v, want newest first without the other rider's ride", who, ids)
+		}
+		for _, q := range []string{"cursor=%25%25%25", "limit=0", "limit=x"} {
+			if rec := serve(s, http.MethodGet, "/api/v1/"+who+"/rides?"+q, ""); rec.Code != http.StatusBadRequest {
+				t.Fatalf("%s?%s: expected 400, got %d", who, q, rec.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/pb"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+)
+
+// Every message written to Kafka carries an envelope in its headers: the
+// payload's content type, the fully qualified name of its protobuf schema
+// and the schema version. Messages without a content type predate the
+// envelope and hold the JSON encoding of the model.
+const (
+	HeaderContentType   = "content-type"
+	HeaderSchema        = "schema"
+	HeaderSchemaVersion = "schema-version"
+
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// SchemaVersion is the version of the schemas in proto/. Compatible
+// changes (new fields) keep it; a bump marks a breaking change, and
+// decoders reject versions newer than the one they were built with.
+const SchemaVersion = 1
+
+// Encoding selects the payload format producers write.
+type Encoding string
+
+const (
+	// EncodingProtobuf writes the messages defined in proto/. It is the
+	// default.
+	EncodingProtobuf Encoding = "protobuf"
+	// EncodingJSON writes the legacy JSON payloads, still with an envelope,
+	// for consumers that have not been upgraded yet.
+	EncodingJSON Encoding = "json"
+)
+
+var (
+	locationSchema  = string((&pb.LocationPing{}).ProtoReflect().Descriptor().FullName())
+	rideEventSchema = string((&pb.RideEvent{}).ProtoReflect().Descriptor().FullName())
+)
+
+// EncodeLocation builds the Kafka message for a location ping, keyed by
+// driver ID.
+func EncodeLocation(d models.Driver, enc Encoding) (kafka.Message, error) {
+	return encode(d.ID, locationSchema, enc, d, DriverToProto(d))
+}
+
+// DecodeLocation decodes a location message in any supported encoding.
+func DecodeLocation(m kafka.Message) (models.Driver, error) {
+	var d models.Driver
+	var p pb.LocationPing
+	isProto, err := decode(m, locationSchema, &d, &p)
+	if err != nil {
+		return models.Driver{}, err
+	}
+	if isProto {
+		d = DriverFromProto(&p)
+	}
+	return d, nil
+}
+
+// EncodeRideEvent builds the Kafka message for a ride event, keyed by ride
+// ID.
+func EncodeRideEvent(e models.RideEvent, enc Encoding) (kafka.Message, error) {
+	return encode(e.RideID, rideEventSchema, enc, e, rideEventToProto(e))
+}
+
+// DecodeRideEvent decodes a ride event message in any supported encoding.
+func DecodeRideEvent(m kafka.Message) (models.RideEvent, error) {
+	var e models.RideEvent
+	var p pb.RideEvent
+	isProto, err := decode(m, rideEventSchema, &e, &p)
+	if err != nil {
+		return models.RideEvent{}, err
+	}
+	if isProto {
+		e = rideEventFromProto(&p)
+	}
+	return e, nil
+}
+
+func encode(key, schema string, enc Encoding, v any, p proto.Message) (kafka.Message, error) {
+	var (
+		b           []byte
+		err         error
+		contentType string
+	)
+	switch enc {
+	case EncodingProtobuf, "":
+		b, err = proto.Marshal(p)
+		contentType = ContentTypeProtobuf
+	case EncodingJSON:
+		b, err = json.Marshal(v)
+		contentType = ContentTypeJSON
+	default:
+		return kafka.Message{}, fmt.Errorf("unknown encoding %q", enc)
+	}
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{Key: []byte(key), Value: b, Headers: []kafka.Header{
+		{Key: HeaderContentType, Value: []byte(contentType)},
+		{Key: HeaderSchema, Value: []byte(schema)},
+		{Key: HeaderSchemaVersion, Value: []byte(strconv.Itoa(SchemaVersion))},
+	}}, nil
+}
+
+// decode checks m's envelope against schema and unmarshals its payload
+// into v for JSON or into p for protobuf, reporting which one it used.
+func decode(m kafka.Message, schema string, v any, p proto.Message) (bool, error) {
+	headers := map[string]string{}
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if s, ok := headers[HeaderSchema]; ok && s != schema {
+		return false, fmt.Errorf("unexpected schema %q, want %q", s, schema)
+	}
+	if s, ok := headers[HeaderSchemaVersion]; ok {
+		version, err := strconv.Atoi(s)
+		if err != nil {
+			return false, fmt.Errorf("invalid schema version %q", s)
+		}
+		if version > SchemaVersion {
+			return false, fmt.Errorf("unsupported schema version %d (max %d)", version, SchemaVersion)
+		}
+	}
+	switch ct := headers[HeaderContentType]; ct {
+	case "", ContentTypeJSON:
+		return false, json.Unmarshal(m.Value, v)
+	case ContentTypeProtobuf:
+		return true, proto.Unmarshal(m.Value, p)
+	default:
+		return false, fmt.Errorf("unsupported content type %q", ct)
+	}
+}
+
+func rideEventToProto(e models.RideEvent) *pb.RideEvent {
+	r := e.Ride
+	p := &pb.RideEvent{
+		Id:         e.ID,
+		RideId:     e.RideID,
+		Type:       e.Type,
+		FromStatus: string(e.FromStatus),
+		ToStatus:   string(e.ToStatus),
+		AtMs:       unixMilli(e.At),
+		Ride: &pb.Ride{
+			Id:              r.ID,
+			RiderId:         r.RiderID,
+			DriverId:        r.DriverID,
+			Origin:          &pb.Coord{Lat: r.Origin.Lat, Lon: r.Origin.Lon},
+			Destination:     &pb.Coord{Lat: r.Destination.Lat, Lon: r.Destination.Lon},
+			Status:          string(r.Status),
+			CanceledBy:      r.CanceledBy,
+			PaymentIntentId: r.PaymentIntentID,
+			PaymentStatus:   string(r.PaymentStatus),
+			CreatedAtMs:     unixMilli(r.CreatedAt),
+			UpdatedAtMs:     unixMilli(r.UpdatedAt),
+		},
+	}
+	if f := r.Fare; f != nil {
+		p.Ride.Fare = &pb.Fare{Amount: f.Amount, Currency: f.Currency, SurgeMultiplier: f.Surge, CancellationFee: f.CancellationFee}
+	}
+	return p
+}
+
+func rideEventFromProto(p *pb.RideEvent) models.RideEvent {
+	r := p.GetRide()
+	e := models.RideEvent{
+		ID:         p.GetId(),
+		RideID:     p.GetRideId(),
+		Type:       p.GetType(),
+		FromStatus: models.RideStatus(p.GetFromStatus()),
+		ToStatus:   models.RideStatus(p.GetToStatus()),
+		At:         fromUnixMilli(p.GetAtMs()),
+		Ride: models.Ride{
+			ID:              r.GetId(),
+			RiderID:         r.GetRiderId(),
+			DriverID:        r.GetDriverId(),
+			Origin:          models.Coord{Lat: r.GetOrigin().GetLat(), Lon: r.GetOrigin().GetLon()},
+			Destination:     models.Coord{Lat: r.GetDestination().GetLat(), Lon: r.GetDestination().GetLon()},
+			Status:          models.RideStatus(r.GetStatus()),
+			CanceledBy:      r.GetCanceledBy(),
+			PaymentIntentID: r.GetPaymentIntentId(),
+			PaymentStatus:   models.PaymentStatus(r.GetPaymentStatus()),
+			CreatedAt:       fromUnixMilli(r.GetCreatedAtMs()),
+			UpdatedAt:       fromUnixMilli(r.GetUpdatedAtMs()),
+		},
+	}
+	if f := r.GetFare(); f != nil {
+		e.Ride.Fare = &models.Fare{Amount: f.GetAmount(), Currency: f.GetCurrency(), Surge: f.GetSurgeMultiplier(), CancellationFee: f.GetCancellationFee()}
+	}
+	return e
+}
+
+// unixMilli maps the zero time to 0 so it survives a round trip.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
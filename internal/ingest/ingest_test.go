@@ -0,0 +1,309 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/pb"
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestValidatePing(t *testing.T) {
+	now := time.Now()
+	ok := models.Driver{ID: "d1", Loc: models.Coord{Lat: 12.97, Lon: 77.59}}
+	cases := map[string]struct {
+		edit  func(d *models.Driver)
+		valid bool
+	}{
+		"ok":            {func(d *models.Driver) {}, true},
+		"missing id":    {func(d *models.Driver) { d.ID = "" }, false},
+		"lat too high":  {func(d *models.Driver) { d.Loc.Lat = 90.5 }, false},
+		"lon too low":   {func(d *models.Driver) { d.Loc.Lon = -181 }, false},
+		"nan lat":       {func(d *models.Driver) { d.Loc.Lat = math.NaN() }, false},
+		"buffered ping": {func(d *models.Driver) { d.RecordedAt = now.Add(-30 * time.Minute) }, true},
+		"too old":       {func(d *models.Driver) { d.RecordedAt = now.Add(-2 * time.Hour) }, false},
+		"clock skew":    {func(d *models.Driver) { d.RecordedAt = now.Add(30 * time.Second) }, true},
+		"future":        {func(d *models.Driver) { d.RecordedAt = now.Add(5 * time.Minute) }, false},
+	}
+	for name, c := range cases {
+		d := ok
+		c.edit(&d)
+		if err := ValidatePing(d, now); (err == nil) != c.valid {
+			t.Errorf("%s: ValidatePing = %v, want valid=%v", name, err, c.valid)
+		}
+	}
+}
+
+func TestLocationBatchRoundTrip(t *testing.T) {
+	in := models.Driver{ID: "d1", Loc: models.Coord{Lat: 12.97, Lon: 77.59}, Rating: 4.8, City: "blr",
+		Heading: heading(90), Speed: 8.5, Accuracy: 5, RecordedAt: time.UnixMilli(1700000000123).UTC(), Seq: 42}
+	b, err := proto.Marshal(&pb.LocationBatch{Pings: []*pb.LocationPing{DriverToProto(in)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch pb.LocationBatch
+	if err := proto.Unmarshal(b, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Pings) != 1 {
+		t.Fatalf("expected 1 ping, got %d", len(batch.Pings))
+	}
+	if out := DriverFromProto(batch.Pings[0]); !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip changed the ping:\n got %+v\nwant %+v", out, in)
+	}
+
+	in.Heading = nil
+	b, _ = proto.Marshal(DriverToProto(in))
+	var ping pb.LocationPing
+	if err := proto.Unmarshal(b, &ping); err != nil {
+		t.Fatal(err)
+	}
+	if out := DriverFromProto(&ping); out.Heading != nil {
+		t.Fatalf("missing heading decoded as %v", *out.Heading)
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	d := models.Driver{ID: "d1", Loc: models.Coord{Lat: 12.97, Lon: 77.59}, Rating: 4.8, RecordedAt: time.UnixMilli(1700000000123).UTC(), Seq: 3}
+	for _, enc := range []Encoding{EncodingProtobuf, EncodingJSON} {
+		m, err := EncodeLocation(d, enc)
+		if err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		got, err := DecodeLocation(m)
+		if err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+		if got != d {
+			t.Fatalf("%s: got %+v, want %+v", enc, got, d)
+		}
+	}
+
+	fare := &models.Fare{Amount: 1250, Currency: "USD", Surge: 1.4}
+	e := models.RideEvent{ID: 9, RideID: "r1", Type: "ride.accepted", FromStatus: models.StatusRequested, ToStatus: models.StatusAccepted,
+		At: time.UnixMilli(1700000000456).UTC(),
+		Ride: models.Ride{ID: "r1", RiderID: "u1", DriverID: "d1", Status: models.StatusAccepted, Fare: fare,
+			Origin: models.Coord{Lat: 1, Lon: 2}, CreatedAt: time.UnixMilli(1700000000000).UTC()}}
+	m, err := EncodeRideEvent(e, EncodingProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeRideEvent(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.Ride.Fare != *fare {
+		t.Fatalf("fare = %+v, want %+v", got.Ride.Fare, fare)
+	}
+	got.Ride.Fare, e.Ride.Fare = nil, nil
+	if got != e {
+		t.Fatalf("got %+v, want %+v", got, e)
+	}
+}
+
+func TestDecodeLocationEnvelope(t *testing.T) {
+	if d, err := DecodeLocation(kafka.Message{Value: []byte(`{"id":"d1","loc":{"lat":1,"lon":2}}`)}); err != nil || d.ID != "d1" {
+		t.Fatalf("legacy message: %+v, %v", d, err)
+	}
+	ride, _ := EncodeRideEvent(models.RideEvent{RideID: "r1"}, EncodingProtobuf)
+	if _, err := DecodeLocation(ride); err == nil {
+		t.Fatal("expected a ride event to be rejected as a location")
+	}
+	m, _ := EncodeLocation(models.Driver{ID: "d1"}, EncodingProtobuf)
+	m.Headers[2].Value = []byte("2")
+	if _, err := DecodeLocation(m); err == nil {
+		t.Fatal("expected a newer schema version to be rejected")
+	}
+	m.Headers = []kafka.Header{{Key: HeaderContentType, Value: []byte("text/plain")}}
+	if _, err := DecodeLocation(m); err == nil {
+		t.Fatal("expected an unknown content type to be rejected")
+	}
+}
+
+func TestAsyncProducerQueuePolicy(t *testing.T) {
+	ctx := context.Background()
+	drop := NewKafkaProducer(ProducerConfig{Brokers: []string{"127.0.0.1:1"}, Topic: "t", Async: true, QueueSize: 2})
+	if err := drop.reserve(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := drop.reserve(ctx, 1); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull from a full queue, got %v", err)
+	}
+	drop.release(1)
+	if err := drop.reserve(ctx, 2); !errors.Is(err, ErrQueueFull) || len(drop.slots) != 1 {
+		t.Fatalf("a batch that does not fit must take no slots: err=%v depth=%d", err, len(drop.slots))
+	}
+
+	block := NewKafkaProducer(ProducerConfig{Brokers: []string{"127.0.0.1:1"}, Topic: "t", Async: true, QueueSize: 1, BlockWhenFull: true})
+	if err := block.reserve(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		block.completed([]kafka.Message{{Time: time.Now()}}, nil)
+	}()
+	if err := block.reserve(ctx, 1); err != nil {
+		t.Fatalf("expected the blocked publish to get the freed slot, got %v", err)
+	}
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := block.reserve(short, 1); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull once the wait times out, got %v", err)
+	}
+}
+
+func TestAsyncProducerReleasesRejectedMessages(t *testing.T) {
+	p := NewKafkaProducer(ProducerConfig{Brokers: []string{"127.0.0.1:1"}, Topic: "t", Async: true, QueueSize: 10})
+	defer p.Close()
+	if err := p.PublishLocations(context.Background(), []models.Driver{{ID: "d1"}, {ID: "d2"}}); err == nil {
+		t.Fatal("expected an unreachable broker to reject the write")
+	}
+	if n := len(p.slots); n != 0 {
+		t.Fatalf("rejected messages still hold %d queue slots", n)
+	}
+}
+
+func TestTelemetryFrameRoundTrip(t *testing.T) {
+	secret := []byte("s3cret")
+	in := models.Driver{ID: "d1", Loc: models.Coord{Lat: 37.7749295, Lon: -122.4194155}, Rating: 4.8,
+		Heading: heading(271.25), Speed: 13.4, Accuracy: 4.5, RecordedAt: time.UnixMilli(1700000000123).UTC(), Seq: 99}
+	frame, err := AppendTelemetryFrame(nil, in, TelemetryDriverKey(secret, "d1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame) != 2+len(in.ID)+telemetryFields+telemetryMACLen {
+		t.Fatalf("frame is %d bytes", len(frame))
+	}
+	out, err := ParseTelemetryFrame(frame, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rating is never sent; it stays server-side
+	want := in
+	want.Rating = 0
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("round trip changed the ping:\n got %+v\nwant %+v", out, want)
+	}
+	// a device without a heading must not read back as heading north
+	in.Heading = nil
+	frame, _ = AppendTelemetryFrame(nil, in, TelemetryDriverKey(secret, "d1"))
+	if out, err := ParseTelemetryFrame(frame, secret); err != nil || out.Heading != nil {
+		t.Fatalf("missing heading decoded as %v, %v", out.Heading, err)
+	}
+
+	// a device cannot sign for another driver, nor alter a signed frame
+	forged, _ := AppendTelemetryFrame(nil, models.Driver{ID: "d2", RecordedAt: in.RecordedAt}, TelemetryDriverKey(secret, "d1"))
+	if _, err := ParseTelemetryFrame(forged, secret); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for a frame signed with another driver's key, got %v", err)
+	}
+	frame[20] ^= 1
+	if _, err := ParseTelemetryFrame(frame, secret); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature for a tampered frame, got %v", err)
+	}
+	for _, bad := range [][]byte{nil, {2, 1, 'x'}, frame[:len(frame)-1], append(frame, 0)} {
+		if _, err := ParseTelemetryFrame(bad, secret); !errors.Is(err, ErrBadFrame) {
+			t.Fatalf("expected ErrBadFrame for %d bytes, got %v", len(bad), err)
+		}
+	}
+}
+
+func TestDriverLimiter(t *testing.T) {
+	l := newDriverLimiter(1, 2)
+	now := time.Now()
+	if !l.allow("d1", now) || !l.allow("d1", now) {
+		t.Fatal("expected a burst of 2 to pass")
+	}
+	if l.allow("d1", now) {
+		t.Fatal("expected the third ping to be limited")
+	}
+	if !l.allow("d2", now) {
+		t.Fatal("drivers must not share a bucket")
+	}
+	if !l.allow("d1", now.Add(time.Second)) {
+		t.Fatal("expected a token after one second")
+	}
+	l.prune(now.Add(time.Minute))
+	if len(l.buckets) != 0 {
+		t.Fatalf("expected idle buckets to be pruned, %d left", len(l.buckets))
+	}
+}
+
+type fakePublisher struct {
+	mu    sync.Mutex
+	pings []models.Driver
+}
+
+func (f *fakePublisher) PublishLocation(ctx context.Context, d models.Driver) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings = append(f.pings, d)
+	return nil
+}
+
+func TestTelemetryReceiverServe(t *testing.T) {
+	secret := []byte("s3cret")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp not available: %v", err)
+	}
+	pub := &fakePublisher{}
+	idx := geo.NewIndex()
+	_ = idx.SetStatus("d1", models.DriverOnline, "")
+	recv := &TelemetryReceiver{Secret: secret, Publisher: pub, Geo: idx, Rate: 1, Burst: 2, Workers: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- recv.Serve(ctx, conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	key := TelemetryDriverKey(secret, "d1")
+	for seq := uint64(1); seq <= 3; seq++ {
+		d := models.Driver{ID: "d1", Loc: models.Coord{Lat: 12.97, Lon: 77.59}, RecordedAt: time.Now(), Seq: seq}
+		frame, _ := AppendTelemetryFrame(nil, d, key)
+		if _, err := client.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unsigned, _ := AppendTelemetryFrame(nil, models.Driver{ID: "d2", RecordedAt: time.Now()}, []byte("wrong"))
+	client.Write(unsigned)
+	noTime, _ := AppendTelemetryFrame(nil, models.Driver{ID: "d3"}, TelemetryDriverKey(secret, "d3"))
+	client.Write(noTime)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		pub.mu.Lock()
+		n := len(pub.pings)
+		pub.mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // let the remaining frames be handled
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	if len(pub.pings) != 2 {
+		t.Fatalf("expected the burst of 2 to be forwarded, got %d pings", len(pub.pings))
+	}
+	got, err := idx.Nearby(12.97, 77.59, geo.SearchOptions{Radius: 1000})
+	if err != nil || len(got) != 1 || got[0].ID != "d1" {
+		t.Fatalf("expected d1 in the geo index, got %+v, %v", got, err)
+	}
+}
+
+func heading(deg float64) *float64 { return &deg }
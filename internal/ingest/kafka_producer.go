@@ -3,31 +3,222 @@ package ingest
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/example/ride-matching/internal/config"
 	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/observability"
+	"github.com/example/ride-matching/internal/storage"
 	"github.com/segmentio/kafka-go"
 )
 
+// ErrQueueFull is returned by an asynchronous KafkaProducer whose queue has
+// no room for the messages being published.
+var ErrQueueFull = errors.New("kafka producer queue is full")
+
+// publishTimeout bounds a synchronous write, or the wait for queue room
+// when an asynchronous producer blocks.
+const publishTimeout = 2 * time.Second
+
+// ProducerConfig configures a KafkaProducer.
+type ProducerConfig struct {
+	Brokers  []string
+	Topic    string
+	Encoding Encoding // empty means EncodingProtobuf
+	// Async queues messages in memory and writes them in the background;
+	// otherwise a publish returns once the write is acknowledged.
+	Async bool
+	// QueueSize bounds the messages an async producer holds that Kafka has
+	// not acknowledged yet.
+	QueueSize int
+	// BlockWhenFull makes publishes wait, up to publishTimeout, for room
+	// in a full queue; otherwise they fail straight away with ErrQueueFull.
+	BlockWhenFull bool
+	// A batch is sent once it holds BatchSize messages or Linger after its
+	// first message, per partition.
+	BatchSize    int
+	Linger       time.Duration
+	RequiredAcks kafka.RequiredAcks
+	Compression  kafka.Compression
+}
+
+// NewProducerConfig builds the producer settings for topic from the
+// environment-level configuration.
+func NewProducerConfig(brokers []string, topic string, enc Encoding, c config.KafkaProducerConfig) (ProducerConfig, error) {
+	pc := ProducerConfig{
+		Brokers:       brokers,
+		Topic:         topic,
+		Encoding:      enc,
+		Async:         c.Mode == "async",
+		QueueSize:     c.QueueSize,
+		BlockWhenFull: c.QueueFull == "block",
+		BatchSize:     c.BatchSize,
+		Linger:        c.Linger,
+	}
+	if err := pc.RequiredAcks.UnmarshalText([]byte(c.Acks)); err != nil {
+		return ProducerConfig{}, fmt.Errorf("KAFKA_PRODUCER_ACKS: %w", err)
+	}
+	if err := pc.Compression.UnmarshalText([]byte(c.Compression)); err != nil {
+		return ProducerConfig{}, fmt.Errorf("KAFKA_PRODUCER_COMPRESSION: %w", err)
+	}
+	return pc, nil
+}
+
+// KafkaProducer publishes location pings to the locations topic.
 type KafkaProducer struct {
-	writer *kafka.Writer
+	writer   *kafka.Writer
+	encoding Encoding
+	async    bool
+	block    bool
+	slots    chan struct{} // one per queued message in async mode
 }
 
-func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
-	w := kafka.NewWriter(kafka.WriterConfig{Brokers: brokers, Topic: topic, Balancer: &kafka.LeastBytes{}})
-	return &KafkaProducer{writer: w}
+func NewKafkaProducer(cfg ProducerConfig) *KafkaProducer {
+	k := &KafkaProducer{encoding: cfg.Encoding, async: cfg.Async, block: cfg.BlockWhenFull}
+	k.writer = &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{}, // by driver ID, so a driver's pings stay in order
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: cfg.Linger,
+		RequiredAcks: cfg.RequiredAcks,
+		Compression:  cfg.Compression,
+		Async:        cfg.Async,
+	}
+	if cfg.Async {
+		k.slots = make(chan struct{}, cfg.QueueSize)
+		k.writer.Completion = k.completed
+	}
+	return k
 }
 
-func (k *KafkaProducer) PublishLocation(d models.Driver) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func (k *KafkaProducer) PublishLocation(ctx context.Context, d models.Driver) error {
+	return k.PublishLocations(ctx, []models.Driver{d})
+}
+
+// PublishLocations writes the pings to the locations topic in a single
+// WriteMessages call. On error some of them may have been written; the
+// consumer drops the duplicates when the caller retries. An async producer
+// returns once the pings are queued; later write failures only show in
+// the ride_matching_kafka_publish_failures_total metric.
+func (k *KafkaProducer) PublishLocations(ctx context.Context, ds []models.Driver) error {
+	now := time.Now()
+	msgs := make([]kafka.Message, len(ds))
+	for i, d := range ds {
+		m, err := EncodeLocation(d, k.encoding)
+		if err != nil {
+			return err
+		}
+		m.Time = now // also lets completed measure the publish latency
+		msgs[i] = m
+	}
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
 	defer cancel()
-	b, _ := json.Marshal(d)
-	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(d.ID), Value: b})
+	if !k.async {
+		err := k.writer.WriteMessages(ctx, msgs...)
+		observability.KafkaPublishLatency.Observe(time.Since(now).Seconds())
+		if err != nil {
+			observability.KafkaPublishFailures.WithLabelValues("write").Add(float64(len(msgs)))
+		}
+		return err
+	}
+	if err := k.reserve(ctx, len(msgs)); err != nil {
+		observability.KafkaPublishFailures.WithLabelValues("queue_full").Add(float64(len(msgs)))
+		return err
+	}
+	if err := k.writer.WriteMessages(ctx, msgs...); err != nil {
+		// the writer rejected the messages before queueing them
+		k.release(len(msgs))
+		observability.KafkaPublishFailures.WithLabelValues("write").Add(float64(len(msgs)))
+		return err
+	}
+	return nil
+}
+
+// reserve takes n queue slots, or none at all.
+func (k *KafkaProducer) reserve(ctx context.Context, n int) error {
+	if n > cap(k.slots) {
+		return ErrQueueFull
+	}
+	for i := 0; i < n; i++ {
+		if k.block {
+			select {
+			case k.slots <- struct{}{}:
+				continue
+			case <-ctx.Done():
+			}
+		} else {
+			select {
+			case k.slots <- struct{}{}:
+				continue
+			default:
+			}
+		}
+		k.release(i)
+		return ErrQueueFull
+	}
+	observability.KafkaProducerQueueDepth.Add(float64(n))
+	return nil
+}
+
+func (k *KafkaProducer) release(n int) {
+	for i := 0; i < n; i++ {
+		<-k.slots
+	}
+	observability.KafkaProducerQueueDepth.Sub(float64(n))
+}
+
+// completed is called by the writer for every batch an async producer
+// sent or gave up on.
+func (k *KafkaProducer) completed(msgs []kafka.Message, err error) {
+	for _, m := range msgs {
+		observability.KafkaPublishLatency.Observe(time.Since(m.Time).Seconds())
+	}
+	if err != nil {
+		observability.KafkaPublishFailures.WithLabelValues("write").Add(float64(len(msgs)))
+	}
+	k.release(len(msgs))
 }
 
+// Close flushes the queued messages and waits for their writes to finish.
 func (k *KafkaProducer) Close() error {
 	if k.writer == nil {
 		return nil
 	}
 	return k.writer.Close()
 }
+
+// KafkaEventPublisher writes outbox messages to the ride events topic. It
+// waits for all in-sync replicas so a batch is only marked sent once it is
+// durable.
+type KafkaEventPublisher struct {
+	writer *kafka.Writer
+	// Encoding of the ride event payloads; empty means EncodingProtobuf.
+	Encoding Encoding
+}
+
+func NewKafkaEventPublisher(brokers []string, topic string) *KafkaEventPublisher {
+	w := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topic, Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll}
+	return &KafkaEventPublisher{writer: w}
+}
+
+func (k *KafkaEventPublisher) Publish(ctx context.Context, msgs []storage.OutboxMessage) error {
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		// the outbox stores events as JSON; re-encode them in the envelope
+		var e models.RideEvent
+		if err := json.Unmarshal(m.Payload, &e); err != nil {
+			return fmt.Errorf("outbox message %d: %w", m.ID, err)
+		}
+		msg, err := EncodeRideEvent(e, k.Encoding)
+		if err != nil {
+			return err
+		}
+		out[i] = msg
+	}
+	return k.writer.WriteMessages(ctx, out...)
+}
+
+func (k *KafkaEventPublisher) Close() error { return k.writer.Close() }
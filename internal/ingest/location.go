@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/pb"
+)
+
+// Bounds on a ping's device timestamp relative to the time it is received.
+// Phones buffer pings while offline, so old ones are expected; the future
+// bound absorbs clock skew.
+const (
+	MaxPingAge  = time.Hour
+	MaxPingSkew = time.Minute
+)
+
+// ValidatePing reports why a location ping received at now cannot be
+// accepted, or nil when it can.
+func ValidatePing(d models.Driver, now time.Time) error {
+	if d.ID == "" {
+		return errors.New("id is required")
+	}
+	if math.IsNaN(d.Loc.Lat) || d.Loc.Lat < -90 || d.Loc.Lat > 90 {
+		return fmt.Errorf("lat %v out of range", d.Loc.Lat)
+	}
+	if math.IsNaN(d.Loc.Lon) || d.Loc.Lon < -180 || d.Loc.Lon > 180 {
+		return fmt.Errorf("lon %v out of range", d.Loc.Lon)
+	}
+	if !d.RecordedAt.IsZero() {
+		if d.RecordedAt.After(now.Add(MaxPingSkew)) {
+			return errors.New("recorded_at is in the future")
+		}
+		if d.RecordedAt.Before(now.Add(-MaxPingAge)) {
+			return errors.New("recorded_at is too old")
+		}
+	}
+	return nil
+}
+
+// DriverFromProto converts a protobuf location ping to the driver model.
+func DriverFromProto(p *pb.LocationPing) models.Driver {
+	return models.Driver{
+		ID:         p.GetDriverId(),
+		Loc:        models.Coord{Lat: p.GetLat(), Lon: p.GetLon()},
+		Rating:     p.GetRating(),
+		City:       p.GetCity(),
+		Heading:    p.Heading,
+		Speed:      p.GetSpeed(),
+		Accuracy:   p.GetAccuracy(),
+		Seq:        p.GetSeq(),
+		RecordedAt: fromUnixMilli(p.GetRecordedAtMs()),
+	}
+}
+
+// DriverToProto is the inverse of DriverFromProto. Availability is not
+// carried by pings and is dropped.
+func DriverToProto(d models.Driver) *pb.LocationPing {
+	return &pb.LocationPing{
+		DriverId:     d.ID,
+		Lat:          d.Loc.Lat,
+		Lon:          d.Loc.Lon,
+		Rating:       d.Rating,
+		City:         d.City,
+		Heading:      d.Heading,
+		Speed:        d.Speed,
+		Accuracy:     d.Accuracy,
+		Seq:          d.Seq,
+		RecordedAtMs: unixMilli(d.RecordedAt),
+	}
+}
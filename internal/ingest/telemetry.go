@@ -0,0 +1,292 @@
+package ingest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/observability"
+)
+
+// Telemetry frames are single UDP datagrams, big-endian:
+//
+//	offset  size  field
+//	0       1     version (TelemetryVersion)
+//	1       1     driver ID length n (1..MaxTelemetryIDLen)
+//	2       n     driver ID
+//	2+n     8     recorded_at, unix milliseconds
+//	10+n    8     seq
+//	18+n    4     lat, 1e-7 degrees (int32)
+//	22+n    4     lon, 1e-7 degrees (int32)
+//	26+n    2     heading, 0.01 degrees; 0xFFFF when unknown
+//	28+n    2     speed, cm/s
+//	30+n    2     accuracy, dm
+//	32+n    16    HMAC-SHA256 of bytes 0..32+n with the driver key, truncated
+//
+// The driver key is TelemetryDriverKey(secret, driverID), handed to the
+// device when the driver signs in, so a device can only sign its own pings.
+// recorded_at is required: together with ValidatePing's age limit and the
+// consumer's ordering check it stops captured frames from being replayed.
+// Frames carry no rating: a device must not be able to rate its own driver,
+// so the stored rating is left alone. Version 1 frames, which did, are
+// rejected.
+const (
+	TelemetryVersion  = 2
+	MaxTelemetryIDLen = 64
+
+	noTelemetryHeading = math.MaxUint16
+
+	telemetryFields = 8 + 8 + 4 + 4 + 2 + 2 + 2
+	telemetryMACLen = 16
+	maxTelemetryLen = 2 + MaxTelemetryIDLen + telemetryFields + telemetryMACLen
+)
+
+var (
+	ErrBadFrame     = errors.New("malformed telemetry frame")
+	ErrBadSignature = errors.New("telemetry frame signature mismatch")
+)
+
+// TelemetryDriverKey derives the key a driver's device signs frames with.
+func TelemetryDriverKey(secret []byte, driverID string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(driverID))
+	return mac.Sum(nil)
+}
+
+// AppendTelemetryFrame appends the signed frame for ping d to b. Values
+// outside a field's range are clamped; d.Rating is not sent.
+func AppendTelemetryFrame(b []byte, d models.Driver, key []byte) ([]byte, error) {
+	if d.ID == "" || len(d.ID) > MaxTelemetryIDLen {
+		return b, fmt.Errorf("driver id must be 1 to %d bytes", MaxTelemetryIDLen)
+	}
+	start := len(b)
+	b = append(b, TelemetryVersion, byte(len(d.ID)))
+	b = append(b, d.ID...)
+	b = binary.BigEndian.AppendUint64(b, uint64(unixMilli(d.RecordedAt)))
+	b = binary.BigEndian.AppendUint64(b, d.Seq)
+	b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(d.Loc.Lat*1e7))))
+	b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(d.Loc.Lon*1e7))))
+	heading := uint16(noTelemetryHeading)
+	if d.Heading != nil {
+		heading = scaled(*d.Heading, 100, noTelemetryHeading-1)
+	}
+	b = binary.BigEndian.AppendUint16(b, heading)
+	b = binary.BigEndian.AppendUint16(b, scaled(d.Speed, 100, math.MaxUint16))
+	b = binary.BigEndian.AppendUint16(b, scaled(d.Accuracy, 10, math.MaxUint16))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b[start:])
+	return append(b, mac.Sum(nil)[:telemetryMACLen]...), nil
+}
+
+func scaled(v, factor float64, max uint16) uint16 {
+	return uint16(math.Max(0, math.Min(math.Round(v*factor), float64(max))))
+}
+
+// ParseTelemetryFrame decodes a frame and checks its signature against the
+// key derived from secret for the driver it names.
+func ParseTelemetryFrame(frame, secret []byte) (models.Driver, error) {
+	if len(frame) < 2 || frame[0] != TelemetryVersion {
+		return models.Driver{}, ErrBadFrame
+	}
+	n := int(frame[1])
+	if n == 0 || n > MaxTelemetryIDLen || len(frame) != 2+n+telemetryFields+telemetryMACLen {
+		return models.Driver{}, ErrBadFrame
+	}
+	id := string(frame[2 : 2+n])
+	body, sig := frame[:len(frame)-telemetryMACLen], frame[len(frame)-telemetryMACLen:]
+	mac := hmac.New(sha256.New, TelemetryDriverKey(secret, id))
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)[:telemetryMACLen]) {
+		return models.Driver{}, ErrBadSignature
+	}
+	f := body[2+n:]
+	d := models.Driver{
+		ID:         id,
+		RecordedAt: fromUnixMilli(int64(binary.BigEndian.Uint64(f[0:]))),
+		Seq:        binary.BigEndian.Uint64(f[8:]),
+		Loc: models.Coord{
+			Lat: float64(int32(binary.BigEndian.Uint32(f[16:]))) / 1e7,
+			Lon: float64(int32(binary.BigEndian.Uint32(f[20:]))) / 1e7,
+		},
+		Speed:    float64(binary.BigEndian.Uint16(f[26:])) / 100,
+		Accuracy: float64(binary.BigEndian.Uint16(f[28:])) / 10,
+	}
+	if h := binary.BigEndian.Uint16(f[24:]); h != noTelemetryHeading {
+		heading := float64(h) / 100
+		d.Heading = &heading
+	}
+	return d, nil
+}
+
+// LocationPublisher forwards accepted pings to the locations topic;
+// KafkaProducer implements it.
+type LocationPublisher interface {
+	PublishLocation(ctx context.Context, d models.Driver) error
+}
+
+// TelemetryReceiver accepts signed location frames over UDP and forwards
+// them like POST /internal/driver/locations: published to Kafka, then
+// written to Geo when one is set. Frames get no reply; every outcome is
+// counted in ride_matching_telemetry_frames_total.
+type TelemetryReceiver struct {
+	Secret    []byte
+	Publisher LocationPublisher
+	Geo       geo.Geo // optional
+	// Rate is the sustained number of pings a driver may send per second,
+	// with bursts of up to Burst.
+	Rate    float64
+	Burst   int
+	Workers int // concurrent readers; defaults to 1
+	Logger  *slog.Logger
+
+	once    sync.Once
+	limiter *driverLimiter
+}
+
+// Serve reads frames from conn until ctx is canceled.
+func (t *TelemetryReceiver) Serve(ctx context.Context, conn net.PacketConn) error {
+	t.init()
+	go func() {
+		<-ctx.Done()
+		_ = conn.SetReadDeadline(time.Now()) // unblocks the readers
+	}()
+	go t.pruneLoop(ctx)
+
+	workers := max(t.Workers, 1)
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() { errs <- t.read(ctx, conn) }()
+	}
+	var err error
+	for i := 0; i < workers; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (t *TelemetryReceiver) init() {
+	t.once.Do(func() { t.limiter = newDriverLimiter(t.Rate, t.Burst) })
+}
+
+func (t *TelemetryReceiver) read(ctx context.Context, conn net.PacketConn) error {
+	buf := make([]byte, maxTelemetryLen+1) // one spare byte exposes oversized datagrams
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		result := t.handle(ctx, buf[:n], time.Now())
+		observability.TelemetryFrames.WithLabelValues(result).Inc()
+	}
+}
+
+// handle processes one frame and returns its result label. Signatures are
+// checked before rate limiting so forged frames cannot use up a driver's
+// allowance.
+func (t *TelemetryReceiver) handle(ctx context.Context, frame []byte, now time.Time) string {
+	d, err := ParseTelemetryFrame(frame, t.Secret)
+	if errors.Is(err, ErrBadSignature) {
+		return "bad_signature"
+	}
+	if err != nil {
+		return "invalid_frame"
+	}
+	if err := ValidatePing(d, now); err != nil || d.RecordedAt.IsZero() {
+		return "invalid_ping"
+	}
+	if !t.limiter.allow(d.ID, now) {
+		return "rate_limited"
+	}
+	if t.Publisher != nil {
+		if err := t.Publisher.PublishLocation(ctx, d); err != nil {
+			if t.Logger != nil {
+				t.Logger.Warn("publish telemetry ping", "driver_id", d.ID, "error", err)
+			}
+			return "publish_failed"
+		}
+	}
+	if t.Geo != nil {
+		t.Geo.Upsert(d)
+	}
+	return "accepted"
+}
+
+func (t *TelemetryReceiver) pruneLoop(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			t.limiter.prune(now)
+		}
+	}
+}
+
+// driverLimiter is a token bucket per driver.
+type driverLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newDriverLimiter(rate float64, burst int) *driverLimiter {
+	return &driverLimiter{rate: rate, burst: float64(max(burst, 1)), buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the driver's bucket if one is left.
+func (l *driverLimiter) allow(id string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[id] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets drivers whose bucket has filled up again, which is the
+// state a new bucket starts in.
+func (l *driverLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, id)
+		}
+	}
+}
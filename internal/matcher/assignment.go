@@ -0,0 +1,97 @@
+package matcher
+
+import "math"
+
+// forbiddenCost marks rider/driver pairs that must not be assigned (the
+// driver was not a candidate for that rider).
+const forbiddenCost = 1e12
+
+// solveAssignment returns, for every row of cost, the column it is assigned
+// to under a minimum total cost matching, or -1 when the row is left
+// unassigned. The matrix may be rectangular; it is padded to a square with
+// forbidden entries and solved with the Hungarian algorithm in O(n^3).
+func solveAssignment(cost [][]float64) []int {
+	rows := len(cost)
+	if rows == 0 {
+		return nil
+	}
+	cols := 0
+	for _, r := range cost {
+		if len(r) > cols {
+			cols = len(r)
+		}
+	}
+	n := rows
+	if cols > n {
+		n = cols
+	}
+	at := func(i, j int) float64 {
+		if i < rows && j < len(cost[i]) {
+			return cost[i][j]
+		}
+		return forbiddenCost
+	}
+
+	// potentials u (rows), v (cols); p[j] is the row matched to column j;
+	// everything is 1-indexed with index 0 as the virtual start column.
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := at(i0-1, j-1) - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	out := make([]int, rows)
+	for i := range out {
+		out[i] = -1
+	}
+	for j := 1; j <= n; j++ {
+		i := p[j] - 1
+		if i < rows && j-1 < cols && at(i, j-1) < forbiddenCost {
+			out[i] = j - 1
+		}
+	}
+	return out
+}
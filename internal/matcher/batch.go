@@ -0,0 +1,118 @@
+package matcher
+
+import (
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+)
+
+type batchEntry struct {
+	rideID string
+	req    models.RideRequest
+	done   chan batchResult
+}
+
+type batchResult struct {
+	offer models.MatchOffer
+	err   error
+}
+
+// matchBatched parks the request until the current batch window closes and
+// returns the first offer made for it.
+func (s *Service) matchBatched(rideID string, req models.RideRequest) (models.MatchOffer, error) {
+	e := &batchEntry{rideID: rideID, req: req, done: make(chan batchResult, 1)}
+	s.batchMu.Lock()
+	s.batch = append(s.batch, e)
+	if len(s.batch) == 1 {
+		time.AfterFunc(s.BatchWindow, s.flushBatch)
+	}
+	s.batchMu.Unlock()
+	res := <-e.done
+	return res.offer, res.err
+}
+
+// flushBatch assigns every request collected in the window at once. Each
+// rider's candidate list is reordered so the globally assigned driver is
+// offered first and drivers assigned to other riders in the batch are left
+// out, which stops neighbouring riders from fighting over the same driver.
+func (s *Service) flushBatch() {
+	s.batchMu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.batchMu.Unlock()
+
+	// requests whose search failed are answered right away and left out
+	ranked := make([][]candidate, 0, len(batch))
+	kept := batch[:0]
+	for _, e := range batch {
+		cands, err := s.rank(e.req.Origin)
+		if err != nil {
+			s.resolveDemand(e.rideID)
+			e.done <- batchResult{err: err}
+			continue
+		}
+		ranked = append(ranked, cands)
+		kept = append(kept, e)
+	}
+	batch = kept
+
+	switch len(batch) {
+	case 0:
+		return
+	case 1:
+		e := batch[0]
+		offer, err := s.start(e.rideID, e.req, ranked[0])
+		e.done <- batchResult{offer, err}
+		return
+	}
+
+	driverCol := make(map[string]int)
+	for i := range batch {
+		for _, c := range ranked[i] {
+			if _, ok := driverCol[c.d.ID]; !ok {
+				driverCol[c.d.ID] = len(driverCol)
+			}
+		}
+	}
+	cost := make([][]float64, len(batch))
+	for i := range batch {
+		cost[i] = make([]float64, len(driverCol))
+		for j := range cost[i] {
+			cost[i][j] = forbiddenCost
+		}
+		for _, c := range ranked[i] {
+			cost[i][driverCol[c.d.ID]] = c.cost
+		}
+	}
+	assign := solveAssignment(cost)
+
+	assignedTo := make(map[string]int, len(batch))
+	for i, col := range assign {
+		if col < 0 {
+			continue
+		}
+		for _, c := range ranked[i] {
+			if driverCol[c.d.ID] == col {
+				assignedTo[c.d.ID] = i
+				break
+			}
+		}
+	}
+
+	for i, e := range batch {
+		ordered := make([]candidate, 0, len(ranked[i]))
+		for _, c := range ranked[i] {
+			owner, taken := assignedTo[c.d.ID]
+			switch {
+			case taken && owner == i:
+				ordered = append([]candidate{c}, ordered...)
+			case !taken:
+				ordered = append(ordered, c)
+			}
+		}
+		go func(e *batchEntry, ordered []candidate) {
+			offer, err := s.start(e.rideID, e.req, ordered)
+			e.done <- batchResult{offer, err}
+		}(e, ordered)
+	}
+}
@@ -1,88 +1,417 @@
 package matcher
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/example/ride-matching/internal/eta"
+	"github.com/example/ride-matching/internal/geo"
 	"github.com/example/ride-matching/internal/models"
 	"github.com/example/ride-matching/internal/observability"
 	"github.com/example/ride-matching/internal/storage"
 )
 
+const (
+	defaultOfferTimeout = 15 * time.Second
+	defaultTripHold     = 4 * time.Hour
+	// offerHoldGrace keeps the driver reserved slightly past the offer timeout
+	// so the hold cannot lapse before the expiry timer releases it.
+	offerHoldGrace = 5 * time.Second
+	// movingSpeedMps is the speed below which a reported heading is too
+	// noisy to penalise; GPS headings of near-stationary devices wander.
+	movingSpeedMps = 2.0
+)
+
+var (
+	// ErrNoPendingOffer is returned when a decision arrives for a ride that has
+	// no outstanding offer (already accepted, exhausted or unknown).
+	ErrNoPendingOffer = errors.New("no pending offer for ride")
+	// ErrNotOffered is returned when the deciding driver is not the one
+	// currently holding the offer.
+	ErrNotOffered = errors.New("ride is not offered to this driver")
+	// ErrNoDrivers is returned by Match when no candidate could be found or
+	// reserved within the search radius.
+	ErrNoDrivers = errors.New("no drivers available")
+	// ErrDriverUnavailable is returned by Decide when the accepting driver's
+	// hold was taken by another ride, e.g. after the offer hold lapsed.
+	ErrDriverUnavailable = errors.New("driver is held by another ride")
+)
+
 type Geo interface {
-	Nearby(lat, lon float64, limit int) []models.Driver
+	Nearby(lat, lon float64, opts geo.SearchOptions) ([]models.Driver, error)
+	Reserve(driverID, holder string, ttl time.Duration) (bool, error)
+	Release(driverID, holder string) error
 }
 
 type Dispatcher interface {
 	Offer(rideID string, offer models.MatchOffer) error
 }
 
+// Surge feeds open requests into surge pricing and prices offers; it is
+// implemented by pricing.Surge.
+type Surge interface {
+	Multiplier(lat, lon float64) float64
+	RecordRequest(rideID string, origin models.Coord)
+	ResolveRequest(rideID string)
+}
+
+// Lifecycle applies ride state transitions once the offer cascade settles;
+// it is implemented by rides.Service.
+type Lifecycle interface {
+	Accept(rideID, driverID string) (*models.Ride, error)
+	Cancel(rideID, by, actorID string) (*models.Ride, error)
+}
+
 type Service struct {
 	Geo             Geo
 	Dispatch        Dispatcher
 	Store           storage.TripStore
+	Rides           Lifecycle
+	Surge           Surge // optional
 	DefaultSpeedMps float64
 	TopN            int
-	ETAClient       eta.Client // optional OSRM client
-	ETACache        *eta.Cache // optional ETA cache
+	Search          geo.SearchOptions // radius and expansion; Limit defaults to TopN
+	ETAClient       eta.Client        // optional OSRM client
+	ETACache        *eta.Cache        // optional ETA cache
+	OfferTimeout    time.Duration     // how long a driver has to answer an offer
+	TripHold        time.Duration     // how long an accepted driver stays reserved
+	BatchWindow     time.Duration     // > 0 enables batched global assignment
+	// HeadingPenalty is added to the cost of a moving driver heading directly
+	// away from the pickup, scaled down to zero for one heading straight at
+	// it; 0 disables.
+	HeadingPenalty time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingRide
+
+	batchMu sync.Mutex
+	batch   []*batchEntry
 }
 
-func (s *Service) Match(rideID string, req models.RideRequest) (models.MatchOffer, bool) {
-	if s.TopN <= 0 {
-		s.TopN = 10
-	}
-	cands := s.Geo.Nearby(req.Origin.Lat, req.Origin.Lon, s.TopN)
-	if len(cands) == 0 {
-		return models.MatchOffer{}, false
+type candidate struct {
+	d      models.Driver
+	etaSec float64
+	cost   float64
+}
+
+// pendingRide tracks the offer cascade for a ride that has not been accepted
+// yet. attempt is bumped every time the current offer is resolved so that a
+// late timer or a stale decision cannot advance the cascade twice.
+type pendingRide struct {
+	cands   []candidate
+	surge   float64
+	next    int
+	attempt int
+	current models.MatchOffer
+	timer   *time.Timer
+}
+
+// Match persists the ride in "requested" state and offers it to the best
+// candidate. Declined or expired offers cascade to the next candidate in the
+// scored list; the ride only becomes "accepted" through Decide. With a
+// BatchWindow set, the request waits for the window to close so candidates
+// can be assigned across all riders in the batch. It returns ErrNoDrivers
+// when nobody could be offered the ride, or the geo index error when the
+// search itself failed.
+//
+// The request counts as surge demand before the search. One that finds no
+// drivers at all keeps counting until it ages out of surge, so unserved
+// demand raises the multiplier where supply is short; otherwise it stops
+// counting once the ride is accepted, canceled or its offers are exhausted,
+// or right away when the search failed.
+func (s *Service) Match(rideID string, req models.RideRequest) (models.MatchOffer, error) {
+	if s.Surge != nil {
+		s.Surge.RecordRequest(rideID, req.Origin)
 	}
-	type scored struct {
-		d      models.Driver
-		etaSec float64
-		cost   float64
+	if s.BatchWindow > 0 {
+		return s.matchBatched(rideID, req)
 	}
-	scoredList := make([]scored, 0, len(cands))
-	for _, d := range cands {
-		var etaSec float64
-		if s.ETACache != nil {
-			if v, ok := s.ETACache.Get(d.Loc, req.Origin); ok {
-				etaSec = v
-			}
-		}
-		if etaSec == 0 {
-			if s.ETAClient != nil {
-				if v, err := s.ETAClient.EstimateSeconds(d.Loc, req.Origin); err == nil {
-					etaSec = v
-					if s.ETACache != nil {
-						s.ETACache.Set(d.Loc, req.Origin, etaSec)
-					}
-				} else {
-					// fallback to naive estimator
-					etaSec = eta.EstimateSeconds(d.Loc, req.Origin, s.DefaultSpeedMps)
-				}
-			} else {
-				etaSec = eta.EstimateSeconds(d.Loc, req.Origin, s.DefaultSpeedMps)
-			}
-		}
-		cost := etaSec + 30.0*(5.0-d.Rating) // cost = w1*eta + w2*(5 - rating)
-		scoredList = append(scoredList, scored{d, etaSec, cost})
+	cands, err := s.rank(req.Origin)
+	if err != nil {
+		s.resolveDemand(rideID)
+		return models.MatchOffer{}, err
 	}
-	sort.Slice(scoredList, func(i, j int) bool { return scoredList[i].cost < scoredList[j].cost })
+	return s.start(rideID, req, cands)
+}
 
-	best := scoredList[0]
-	offer := models.MatchOffer{DriverID: best.d.ID, ETA: best.etaSec, Cost: best.cost}
-	_ = s.Dispatch.Offer(rideID, offer) // best-effort for this demo
-	observability.MatchesTotal.Inc()
+// start persists the ride and begins the offer cascade over cands in order.
+func (s *Service) start(rideID string, req models.RideRequest, cands []candidate) (models.MatchOffer, error) {
+	if len(cands) == 0 {
+		return models.MatchOffer{}, ErrNoDrivers
+	}
+	now := time.Now()
 	r := &models.Ride{
 		ID:          rideID,
 		RiderID:     req.RiderID,
-		DriverID:    best.d.ID,
 		Origin:      req.Origin,
 		Destination: req.Destination,
-		Status:      "matched",
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		Status:      models.StatusRequested,
+		Fare:        req.Fare,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if req.PaymentIntentID != "" {
+		r.PaymentIntentID = req.PaymentIntentID
+		r.PaymentStatus = models.PaymentHeld
 	}
 	_ = s.Store.SaveRide(r)
-	return offer, true
+
+	p := &pendingRide{cands: cands, surge: 1}
+	if s.Surge != nil {
+		p.surge = s.Surge.Multiplier(req.Origin.Lat, req.Origin.Lon)
+	}
+	if req.Fare != nil {
+		// a quoted ride keeps the multiplier it was priced at
+		p.surge = req.Fare.Surge
+	}
+	s.mu.Lock()
+	if s.pending == nil {
+		s.pending = make(map[string]*pendingRide)
+	}
+	s.pending[rideID] = p
+	s.mu.Unlock()
+
+	if offer, ok := s.offerNext(rideID); ok {
+		return offer, nil
+	}
+	return models.MatchOffer{}, ErrNoDrivers
+}
+
+// Decide applies a driver's answer to the outstanding offer for a ride.
+func (s *Service) Decide(dec models.MatchDecision) error {
+	s.mu.Lock()
+	p, ok := s.pending[dec.RideID]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNoPendingOffer
+	}
+	if p.current.DriverID == "" || p.current.DriverID != dec.DriverID {
+		s.mu.Unlock()
+		return ErrNotOffered
+	}
+	p.resolve()
+	if !dec.Accepted {
+		s.mu.Unlock()
+		_ = s.Geo.Release(dec.DriverID, dec.RideID)
+		s.offerNext(dec.RideID)
+		return nil
+	}
+	s.mu.Unlock()
+
+	// keep the driver out of Nearby for the whole trip. The hold is
+	// extended before the ride is accepted, so a cancel racing the accept
+	// releases it for good instead of being overwritten afterwards.
+	held, err := s.Geo.Reserve(dec.DriverID, dec.RideID, s.tripHold())
+	if err == nil && !held {
+		err = ErrDriverUnavailable
+	}
+	if err != nil {
+		_ = s.Geo.Release(dec.DriverID, dec.RideID)
+		s.offerNext(dec.RideID)
+		return fmt.Errorf("hold driver for trip: %w", err)
+	}
+	s.mu.Lock()
+	if s.pending[dec.RideID] != p {
+		// the ride was canceled while the hold was being extended
+		s.mu.Unlock()
+		_ = s.Geo.Release(dec.DriverID, dec.RideID)
+		return ErrNoPendingOffer
+	}
+	delete(s.pending, dec.RideID)
+	s.mu.Unlock()
+	s.resolveDemand(dec.RideID)
+
+	r, err := s.Rides.Accept(dec.RideID, dec.DriverID)
+	if err != nil {
+		// e.g. the rider canceled while the driver was answering
+		_ = s.Geo.Release(dec.DriverID, dec.RideID)
+		return err
+	}
+	observability.MatchesTotal.Inc()
+	observability.MatchLatency.Observe(r.UpdatedAt.Sub(r.CreatedAt).Seconds())
+	return nil
+}
+
+// offerNext dispatches the ride to the next untried candidate. Drivers that
+// are already reserved by another ride or that the dispatcher cannot reach
+// are skipped immediately; once the list is exhausted the ride is canceled.
+func (s *Service) offerNext(rideID string) (models.MatchOffer, bool) {
+	for {
+		s.mu.Lock()
+		p, ok := s.pending[rideID]
+		if !ok {
+			s.mu.Unlock()
+			return models.MatchOffer{}, false
+		}
+		if p.next >= len(p.cands) {
+			delete(s.pending, rideID)
+			s.mu.Unlock()
+			s.resolveDemand(rideID)
+			_, _ = s.Rides.Cancel(rideID, models.CanceledBySystem, "")
+			return models.MatchOffer{}, false
+		}
+		c := p.cands[p.next]
+		p.next++
+		s.mu.Unlock()
+
+		timeout := s.offerTimeout()
+		if held, err := s.Geo.Reserve(c.d.ID, rideID, timeout+offerHoldGrace); err != nil || !held {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.pending[rideID] != p {
+			// the ride went away while we were reserving
+			s.mu.Unlock()
+			_ = s.Geo.Release(c.d.ID, rideID)
+			return models.MatchOffer{}, false
+		}
+		p.attempt++
+		attempt := p.attempt
+		offer := models.MatchOffer{
+			RideID:    rideID,
+			DriverID:  c.d.ID,
+			ETA:       c.etaSec,
+			Cost:      c.cost,
+			Surge:     p.surge,
+			ExpiresAt: time.Now().Add(timeout),
+		}
+		p.current = offer
+		p.timer = time.AfterFunc(timeout, func() { s.expire(rideID, attempt) })
+		s.mu.Unlock()
+
+		if err := s.Dispatch.Offer(rideID, offer); err == nil {
+			return offer, true
+		}
+		if !s.skip(rideID, attempt) {
+			return models.MatchOffer{}, false
+		}
+	}
+}
+
+// CancelOffer stops the offer cascade for a ride and releases the driver
+// currently holding the offer. It reports whether the ride was pending.
+func (s *Service) CancelOffer(rideID string) bool {
+	s.mu.Lock()
+	p, ok := s.pending[rideID]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	driverID := p.current.DriverID
+	p.resolve()
+	delete(s.pending, rideID)
+	s.mu.Unlock()
+	s.resolveDemand(rideID)
+	if driverID != "" {
+		_ = s.Geo.Release(driverID, rideID)
+	}
+	return true
+}
+
+// resolveDemand stops counting the ride as open demand for surge pricing.
+func (s *Service) resolveDemand(rideID string) {
+	if s.Surge != nil {
+		s.Surge.ResolveRequest(rideID)
+	}
+}
+
+// skip resolves the given attempt without a driver answer and releases the
+// driver. It reports false when the attempt was already resolved elsewhere.
+func (s *Service) skip(rideID string, attempt int) bool {
+	s.mu.Lock()
+	p, ok := s.pending[rideID]
+	if !ok || p.attempt != attempt {
+		s.mu.Unlock()
+		return false
+	}
+	driverID := p.current.DriverID
+	p.resolve()
+	s.mu.Unlock()
+	_ = s.Geo.Release(driverID, rideID)
+	return true
+}
+
+func (s *Service) expire(rideID string, attempt int) {
+	if s.skip(rideID, attempt) {
+		s.offerNext(rideID)
+	}
+}
+
+func (s *Service) offerTimeout() time.Duration {
+	if s.OfferTimeout <= 0 {
+		return defaultOfferTimeout
+	}
+	return s.OfferTimeout
+}
+
+func (s *Service) tripHold() time.Duration {
+	if s.TripHold <= 0 {
+		return defaultTripHold
+	}
+	return s.TripHold
+}
+
+// resolve closes the current offer; callers must hold Service.mu.
+func (p *pendingRide) resolve() {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.attempt++
+	p.current = models.MatchOffer{}
+}
+
+// rank scores nearby drivers for a pickup point, cheapest first.
+func (s *Service) rank(origin models.Coord) ([]candidate, error) {
+	opts := s.Search
+	if opts.Limit <= 0 {
+		opts.Limit = s.TopN
+	}
+	cands, err := s.Geo.Nearby(origin.Lat, origin.Lon, opts)
+	if err != nil {
+		return nil, err
+	}
+	scoredList := make([]candidate, 0, len(cands))
+	for _, d := range cands {
+		etaSec := s.pickupETA(d.Loc, origin)
+		cost := etaSec + 30.0*(5.0-d.Rating) + s.headingCost(d, origin) // cost = w1*eta + w2*(5 - rating) + heading
+		scoredList = append(scoredList, candidate{d, etaSec, cost})
+	}
+	sort.Slice(scoredList, func(i, j int) bool { return scoredList[i].cost < scoredList[j].cost })
+	return scoredList, nil
+}
+
+// headingCost penalises a driver moving away from the pickup point in
+// proportion to (1 - cos θ)/2, where θ is the angle between the driver's
+// heading and the bearing to the pickup. Drivers without a reported heading
+// are not penalised.
+func (s *Service) headingCost(d models.Driver, pickup models.Coord) float64 {
+	if s.HeadingPenalty <= 0 || d.Heading == nil || d.Speed < movingSpeedMps {
+		return 0
+	}
+	toPickup := geo.Bearing(d.Loc.Lat, d.Loc.Lon, pickup.Lat, pickup.Lon)
+	theta := (*d.Heading - toPickup) * math.Pi / 180
+	return s.HeadingPenalty.Seconds() * (1 - math.Cos(theta)) / 2
+}
+
+func (s *Service) pickupETA(from, to models.Coord) float64 {
+	if s.ETACache != nil {
+		if v, ok := s.ETACache.Get(from, to); ok && v != 0 {
+			return v
+		}
+	}
+	if s.ETAClient != nil {
+		if v, err := s.ETAClient.EstimateSeconds(from, to); err == nil {
+			if s.ETACache != nil {
+				s.ETACache.Set(from, to, v)
+			}
+			return v
+		}
+	}
+	// fallback to naive estimator
+	return eta.EstimateSeconds(from, to, s.DefaultSpeedMps)
 }
@@ -1,30 +1,367 @@
 package matcher
 
 import (
-    "testing"
-    "github.com/example/ride-matching/internal/models"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/pricing"
+	"github.com/example/ride-matching/internal/rides"
+	"github.com/example/ride-matching/internal/storage"
 )
 
-type fakeGeo struct{ drivers []models.Driver }
-func (f *fakeGeo) Nearby(lat, lon float64, limit int) []models.Driver { return f.drivers }
+type fakeGeo struct {
+	drivers []models.Driver
+	err     error
+}
+
+func (f *fakeGeo) Nearby(lat, lon float64, opts geo.SearchOptions) ([]models.Driver, error) {
+	return f.drivers, f.err
+}
+func (f *fakeGeo) Reserve(driverID, holder string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+func (f *fakeGeo) Release(driverID, holder string) error { return nil }
 
 type nopDisp struct{}
+
 func (n *nopDisp) Offer(rideID string, offer models.MatchOffer) error { return nil }
 
-type memStore struct{ r *models.Ride }
-func (m *memStore) SaveRide(r *models.Ride) error { m.r = r; return nil }
-func (m *memStore) UpdateRide(r *models.Ride) error { m.r = r; return nil }
+func ride(t *testing.T, store storage.TripStore, id string) *models.Ride {
+	t.Helper()
+	r, err := store.GetRide(id)
+	if err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	return r
+}
 
 func TestChooseHigherRatingIfETAEqual(t *testing.T) {
-    g := &fakeGeo{drivers: []models.Driver{
-        {ID:"A", Loc: models.Coord{Lat:0,Lon:0}, Rating:4.0, Online:true},
-        {ID:"B", Loc: models.Coord{Lat:0,Lon:0}, Rating:5.0, Online:true},
-    }}
-    s := &Service{Geo:g, Dispatch:&nopDisp{}, Store:&memStore{}, DefaultSpeedMps:10, TopN:2}
-    req := models.RideRequest{RiderID:"r1", Origin:models.Coord{Lat:0,Lon:0}, Destination:models.Coord{Lat:0.1,Lon:0.1}}
-    offer, ok := s.Match("ride1", req)
-    if !ok { t.Fatal("no match") }
-    if offer.DriverID != "B" {
-        t.Fatalf("expected B, got %s", offer.DriverID)
-    }
+	g := &fakeGeo{drivers: []models.Driver{
+		{ID: "A", Loc: models.Coord{Lat: 0, Lon: 0}, Rating: 4.0, Online: true},
+		{ID: "B", Loc: models.Coord{Lat: 0, Lon: 0}, Rating: 5.0, Online: true},
+	}}
+	s := &Service{Geo: g, Dispatch: &nopDisp{}, Store: storage.NewMemoryStore(), DefaultSpeedMps: 10, TopN: 2}
+	req := models.RideRequest{RiderID: "r1", Origin: models.Coord{Lat: 0, Lon: 0}, Destination: models.Coord{Lat: 0.1, Lon: 0.1}}
+	offer, err := s.Match("ride1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.DriverID != "B" {
+		t.Fatalf("expected B, got %s", offer.DriverID)
+	}
+}
+
+func TestHeadingPenaltyPrefersDriverApproaching(t *testing.T) {
+	pickup := models.Coord{Lat: 0, Lon: 0}
+	// both drivers are 0.01° south of the pickup; A is better rated but
+	// driving south, away from the rider, while B drives north toward them
+	g := &fakeGeo{drivers: []models.Driver{
+		{ID: "A", Loc: models.Coord{Lat: -0.01, Lon: 0}, Rating: 5.0, Online: true, Heading: heading(180), Speed: 12},
+		{ID: "B", Loc: models.Coord{Lat: -0.01, Lon: 0}, Rating: 4.8, Online: true, Heading: heading(0), Speed: 12},
+	}}
+	req := models.RideRequest{RiderID: "r1", Origin: pickup, Destination: models.Coord{Lat: 0.1, Lon: 0.1}}
+	for _, c := range []struct {
+		penalty time.Duration
+		want    string
+	}{{0, "A"}, {2 * time.Minute, "B"}} {
+		s := &Service{Geo: g, Dispatch: &nopDisp{}, Store: storage.NewMemoryStore(), DefaultSpeedMps: 10, TopN: 2, HeadingPenalty: c.penalty}
+		offer, err := s.Match("ride1", req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offer.DriverID != c.want {
+			t.Fatalf("penalty %s: expected %s, got %s", c.penalty, c.want, offer.DriverID)
+		}
+	}
+}
+
+type recordingDisp struct {
+	mu     sync.Mutex
+	offers []models.MatchOffer
+}
+
+func (r *recordingDisp) Offer(rideID string, offer models.MatchOffer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.offers = append(r.offers, offer)
+	return nil
+}
+
+func (r *recordingDisp) drivers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.offers))
+	for _, o := range r.offers {
+		out = append(out, o.DriverID)
+	}
+	return out
+}
+
+func threeDrivers() *fakeGeo {
+	return &fakeGeo{drivers: []models.Driver{
+		{ID: "A", Rating: 5.0, Online: true},
+		{ID: "B", Rating: 4.5, Online: true},
+		{ID: "C", Rating: 4.0, Online: true},
+	}}
+}
+
+func TestDeclineCascadesAndAcceptPersists(t *testing.T) {
+	disp := &recordingDisp{}
+	store := storage.NewMemoryStore()
+	s := &Service{Geo: threeDrivers(), Dispatch: disp, Store: store, Rides: &rides.Service{Store: store}, TopN: 3, OfferTimeout: time.Minute}
+	if _, err := s.Match("ride1", models.RideRequest{RiderID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	if st := ride(t, store, "ride1").Status; st != models.StatusRequested {
+		t.Fatalf("expected requested, got %s", st)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "B", Accepted: true}); err != ErrNotOffered {
+		t.Fatalf("expected ErrNotOffered, got %v", err)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "A"}); err != nil {
+		t.Fatalf("decline: %v", err)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "B", Accepted: true}); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if got := disp.drivers(); len(got) != 2 || got[0] != "A" || got[1] != "B" {
+		t.Fatalf("unexpected offer order %v", got)
+	}
+	if r := ride(t, store, "ride1"); r.Status != models.StatusAccepted || r.DriverID != "B" {
+		t.Fatalf("expected accepted by B, got %s/%s", r.Status, r.DriverID)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "B", Accepted: true}); err != ErrNoPendingOffer {
+		t.Fatalf("expected ErrNoPendingOffer, got %v", err)
+	}
+}
+
+func TestExpiredOffersCascadeUntilExhausted(t *testing.T) {
+	disp := &recordingDisp{}
+	store := storage.NewMemoryStore()
+	s := &Service{Geo: threeDrivers(), Dispatch: disp, Store: store, Rides: &rides.Service{Store: store}, TopN: 3, OfferTimeout: 5 * time.Millisecond}
+	if _, err := s.Match("ride1", models.RideRequest{RiderID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && ride(t, store, "ride1").Status != models.StatusCanceled {
+		time.Sleep(time.Millisecond)
+	}
+	if st := ride(t, store, "ride1").Status; st != models.StatusCanceled {
+		t.Fatalf("expected canceled after exhausting candidates, got %s", st)
+	}
+	if got := disp.drivers(); len(got) != 3 {
+		t.Fatalf("expected all three drivers offered, got %v", got)
+	}
+}
+
+func TestSolveAssignmentMinimisesTotalCost(t *testing.T) {
+	cost := [][]float64{
+		{4, 1, 3},
+		{2, 0, 5},
+	}
+	got := solveAssignment(cost)
+	// row 0 -> col 1 (1) + row 1 -> col 0 (2) = 3 beats any other pairing
+	if got[0] != 1 || got[1] != 0 {
+		t.Fatalf("unexpected assignment %v", got)
+	}
+	got = solveAssignment([][]float64{{1}, {2}, {forbiddenCost}})
+	if got[0] != 0 || got[1] != -1 || got[2] != -1 {
+		t.Fatalf("expected only the cheapest row assigned, got %v", got)
+	}
+}
+
+func TestBatchAvoidsNearestDriverConflict(t *testing.T) {
+	g := &fakeGeo{drivers: []models.Driver{
+		{ID: "A", Loc: models.Coord{Lat: 0, Lon: 0.010}, Rating: 5.0, Online: true},
+		{ID: "B", Loc: models.Coord{Lat: 0, Lon: -0.012}, Rating: 5.0, Online: true},
+	}}
+	s := &Service{Geo: g, Dispatch: &nopDisp{}, Store: storage.NewMemoryStore(), DefaultSpeedMps: 10, TopN: 2, OfferTimeout: time.Minute, BatchWindow: 20 * time.Millisecond}
+	reqs := map[string]models.RideRequest{
+		"ride1": {RiderID: "r1", Origin: models.Coord{Lat: 0, Lon: 0}},
+		"ride2": {RiderID: "r2", Origin: models.Coord{Lat: 0, Lon: 0.020}},
+	}
+	var mu sync.Mutex
+	got := make(map[string]string)
+	var wg sync.WaitGroup
+	for id, req := range reqs {
+		wg.Add(1)
+		go func(id string, req models.RideRequest) {
+			defer wg.Done()
+			offer, err := s.Match(id, req)
+			if err != nil {
+				t.Errorf("%s: %v", id, err)
+				return
+			}
+			mu.Lock()
+			got[id] = offer.DriverID
+			mu.Unlock()
+		}(id, req)
+	}
+	wg.Wait()
+	// greedy would offer A to both riders; the batch splits them
+	if got["ride1"] != "B" || got["ride2"] != "A" {
+		t.Fatalf("unexpected batch assignment %v", got)
+	}
+}
+
+func TestReservedDriverIsNotOfferedTwice(t *testing.T) {
+	idx := geo.NewIndex()
+	idx.Upsert(models.Driver{ID: "A", Rating: 5.0, Online: true})
+	store := storage.NewMemoryStore()
+	s := &Service{Geo: idx, Dispatch: &nopDisp{}, Store: store, Rides: &rides.Service{Store: store, Geo: idx}, TopN: 2, OfferTimeout: time.Minute}
+	if _, err := s.Match("ride1", models.RideRequest{RiderID: "r1"}); err != nil {
+		t.Fatalf("ride1: %v", err)
+	}
+	if _, err := s.Match("ride2", models.RideRequest{RiderID: "r2"}); !errors.Is(err, ErrNoDrivers) {
+		t.Fatalf("ride2 should not be offered a driver held by ride1, got %v", err)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "A"}); err != nil {
+		t.Fatalf("decline: %v", err)
+	}
+	if got, _ := idx.Nearby(0, 0, geo.SearchOptions{Limit: 2}); len(got) != 1 {
+		t.Fatalf("expected A back in search after decline, got %v", got)
+	}
+	if _, err := s.Match("ride3", models.RideRequest{RiderID: "r3"}); err != nil {
+		t.Fatalf("ride3: %v", err)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride3", DriverID: "A", Accepted: true}); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if got, _ := idx.Nearby(0, 0, geo.SearchOptions{Limit: 2}); len(got) != 0 {
+		t.Fatalf("expected A hidden while on trip, got %v", got)
+	}
+}
+
+func TestHeadingPenaltySkipsDriversWithoutHeading(t *testing.T) {
+	// both drivers are 0.01° north of the pickup and moving; B drives south
+	// toward the rider while A's device reports speed but no heading, which
+	// must not be read as driving north, away from the rider
+	g := &fakeGeo{drivers: []models.Driver{
+		{ID: "A", Loc: models.Coord{Lat: 0.01, Lon: 0}, Rating: 5.0, Online: true, Speed: 12},
+		{ID: "B", Loc: models.Coord{Lat: 0.01, Lon: 0}, Rating: 4.8, Online: true, Heading: heading(180), Speed: 12},
+	}}
+	s := &Service{Geo: g, Dispatch: &nopDisp{}, Store: storage.NewMemoryStore(), DefaultSpeedMps: 10, TopN: 2, HeadingPenalty: 2 * time.Minute}
+	offer, err := s.Match("ride1", models.RideRequest{RiderID: "r1", Destination: models.Coord{Lat: 0.1, Lon: 0.1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.DriverID != "A" {
+		t.Fatalf("expected A, got %s", offer.DriverID)
+	}
+}
+
+func TestMatchSeparatesNoDriversFromSearchErrors(t *testing.T) {
+	store := storage.NewMemoryStore()
+	s := &Service{Geo: &fakeGeo{}, Dispatch: &nopDisp{}, Store: store, TopN: 2}
+	if _, err := s.Match("ride1", models.RideRequest{RiderID: "r1"}); !errors.Is(err, ErrNoDrivers) {
+		t.Fatalf("expected ErrNoDrivers, got %v", err)
+	}
+	down := errors.New("redis down")
+	s.Geo = &fakeGeo{err: down}
+	if _, err := s.Match("ride2", models.RideRequest{RiderID: "r2"}); !errors.Is(err, down) {
+		t.Fatalf("expected search error, got %v", err)
+	}
+	if _, err := store.GetRide("ride2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("failed search should not persist the ride, got %v", err)
+	}
+}
+
+func TestUnservedRequestsRaiseSurge(t *testing.T) {
+	surge := pricing.NewSurge(pricing.SurgeConfig{Sensitivity: 1, Smoothing: 1})
+	origin := models.Coord{Lat: 37.7749, Lon: -122.4194}
+	s := &Service{Geo: &fakeGeo{}, Dispatch: &nopDisp{}, Store: storage.NewMemoryStore(), Surge: surge, TopN: 2}
+	for _, id := range []string{"ride1", "ride2"} {
+		if _, err := s.Match(id, models.RideRequest{RiderID: "r1", Origin: origin}); !errors.Is(err, ErrNoDrivers) {
+			t.Fatalf("%s: expected ErrNoDrivers, got %v", id, err)
+		}
+	}
+	// a failed search is not demand
+	s.Geo = &fakeGeo{err: errors.New("redis down")}
+	if _, err := s.Match("ride3", models.RideRequest{RiderID: "r1", Origin: origin}); err == nil {
+		t.Fatal("expected search error")
+	}
+	surge.Recompute()
+	// two requests and no drivers target 1 + 1*(2-1) = 2
+	if m := surge.Multiplier(origin.Lat, origin.Lon); m != 2 {
+		t.Fatalf("multiplier = %v, want 2", m)
+	}
+}
+
+func heading(deg float64) *float64 { return &deg }
+
+// holdGeo is a fakeGeo that records reservations and can refuse trip holds.
+type holdGeo struct {
+	fakeGeo
+	mu       sync.Mutex
+	refuse   bool // refuse holds longer than an offer
+	holds    []time.Duration
+	released []string
+}
+
+func (g *holdGeo) Reserve(driverID, holder string, ttl time.Duration) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holds = append(g.holds, ttl)
+	return !(g.refuse && ttl > time.Hour), nil
+}
+
+func (g *holdGeo) Release(driverID, holder string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.released = append(g.released, driverID)
+	return nil
+}
+
+// cancelingLifecycle cancels the ride instead of accepting it, as if the
+// rider canceled while the driver was accepting.
+type cancelingLifecycle struct{ g *holdGeo }
+
+func (c *cancelingLifecycle) Accept(rideID, driverID string) (*models.Ride, error) {
+	c.g.mu.Lock()
+	defer c.g.mu.Unlock()
+	if len(c.g.holds) == 0 || c.g.holds[len(c.g.holds)-1] != 4*time.Hour {
+		return nil, errors.New("accepted before the trip hold was taken")
+	}
+	return nil, rides.ErrIllegalTransition
+}
+
+func (c *cancelingLifecycle) Cancel(rideID, by, actorID string) (*models.Ride, error) {
+	return &models.Ride{ID: rideID}, nil
+}
+
+func TestAcceptTakesTripHoldFirst(t *testing.T) {
+	g := &holdGeo{fakeGeo: fakeGeo{drivers: []models.Driver{{ID: "A", Rating: 5, Online: true}}}}
+	s := &Service{Geo: g, Dispatch: &nopDisp{}, Store: storage.NewMemoryStore(), Rides: &cancelingLifecycle{g}, TopN: 1, TripHold: 4 * time.Hour}
+	if _, err := s.Match("ride1", models.RideRequest{RiderID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "A", Accepted: true})
+	if !errors.Is(err, rides.ErrIllegalTransition) {
+		t.Fatalf("expected the canceled ride to reject the accept, got %v", err)
+	}
+	// the cancel won the race, so the trip hold taken first is released
+	if len(g.released) != 1 || g.released[0] != "A" {
+		t.Fatalf("expected A to be released, got %v", g.released)
+	}
+}
+
+func TestAcceptFailsWhenTripHoldIsRefused(t *testing.T) {
+	g := &holdGeo{fakeGeo: fakeGeo{drivers: []models.Driver{{ID: "A", Rating: 5, Online: true}}}, refuse: true}
+	store := storage.NewMemoryStore()
+	s := &Service{Geo: g, Dispatch: &nopDisp{}, Store: store, Rides: &rides.Service{Store: store}, TopN: 1, TripHold: 4 * time.Hour}
+	if _, err := s.Match("ride1", models.RideRequest{RiderID: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Decide(models.MatchDecision{RideID: "ride1", DriverID: "A", Accepted: true}); !errors.Is(err, ErrDriverUnavailable) {
+		t.Fatalf("expected ErrDriverUnavailable, got %v", err)
+	}
+	// the only candidate is gone, so the ride is canceled rather than
+	// accepted without a hold
+	if r := ride(t, store, "ride1"); r.Status != models.StatusCanceled {
+		t.Fatalf("expected canceled ride, got %s", r.Status)
+	}
 }
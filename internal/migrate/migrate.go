@@ -0,0 +1,245 @@
+// Package migrate applies versioned SQL migrations to Postgres. Applied
+// versions are recorded in schema_migrations together with a checksum of
+// their up script, and every run holds a Postgres advisory lock so replicas
+// booting at the same time cannot apply the same migration twice.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lockID is the advisory lock key shared by every migration runner.
+const lockID int64 = 0x72696465 // "ride"
+
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of Up
+}
+
+// State describes a migration as seen by Status.
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the recorded checksum differs from the file.
+	Modified bool
+}
+
+// Load reads NNN_name.up.sql / NNN_name.down.sql pairs from fsys and returns
+// them ordered by version. Every version needs an up script; down scripts
+// are optional but Down refuses to revert a migration without one.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 001_description.up.sql", e.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up script", mig.Version, mig.Name)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+type Runner struct {
+	DB         *sql.DB
+	Migrations []Migration
+	Logger     *slog.Logger
+}
+
+type appliedRow struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies every pending migration in order and returns how many ran.
+func (r *Runner) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range r.Migrations {
+			if row, ok := applied[m.Version]; ok {
+				if row.checksum != m.Checksum {
+					return fmt.Errorf("migration %03d_%s was modified after it was applied", m.Version, m.Name)
+				}
+				continue
+			}
+			if err := r.exec(ctx, conn, m.Up, `INSERT INTO schema_migrations(version, name, checksum) VALUES($1, $2, $3)`, m.Version, m.Name, m.Checksum); err != nil {
+				return fmt.Errorf("apply %03d_%s: %w", m.Version, m.Name, err)
+			}
+			r.log("migration applied", m)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down reverts the latest steps applied migrations, newest first.
+func (r *Runner) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(r.Migrations) - 1; i >= 0 && n < steps; i-- {
+			m := r.Migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %03d_%s has no down script", m.Version, m.Name)
+			}
+			if err := r.exec(ctx, conn, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+				return fmt.Errorf("revert %03d_%s: %w", m.Version, m.Name, err)
+			}
+			r.log("migration reverted", m)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (r *Runner) Status(ctx context.Context) ([]State, error) {
+	var out []State
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range r.Migrations {
+			st := State{Migration: m}
+			if row, ok := applied[m.Version]; ok {
+				st.Applied = true
+				st.AppliedAt = row.appliedAt
+				st.Modified = row.checksum != m.Checksum
+			}
+			out = append(out, st)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// withLock runs fn on a dedicated connection holding the advisory lock;
+// session-level advisory locks belong to a connection, not to the pool.
+func (r *Runner) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		// use a fresh context so a canceled ctx still releases the lock
+		if _, uerr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); uerr != nil && err == nil {
+			err = fmt.Errorf("release migration lock: %w", uerr)
+		}
+	}()
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func (r *Runner) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedRow, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int64]appliedRow)
+	for rows.Next() {
+		var v int64
+		var row appliedRow
+		if err := rows.Scan(&v, &row.checksum, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		out[v] = row
+	}
+	return out, rows.Err()
+}
+
+// exec runs a migration script and its bookkeeping statement in one
+// transaction.
+func (r *Runner) exec(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+func (r *Runner) log(msg string, m Migration) {
+	if r.Logger != nil {
+		r.Logger.Info(msg, "version", m.Version, "name", m.Name)
+	}
+}
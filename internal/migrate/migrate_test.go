@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/example/ride-matching/migrations"
+)
+
+func TestLoadPairsAndOrdersMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"010_add_index.up.sql":     {Data: []byte("CREATE INDEX x ON t(a);")},
+		"002_create_t.up.sql":      {Data: []byte("CREATE TABLE t(a INT);")},
+		"002_create_t.down.sql":    {Data: []byte("DROP TABLE t;")},
+		"README.md":                {Data: []byte("ignored")},
+		"010_add_index.down.sql":   {Data: []byte("DROP INDEX x;")},
+		"001_bootstrap.up.sql":     {Data: []byte("SELECT 1;")},
+		"001_bootstrap.down.sql":   {Data: []byte("SELECT 1;")},
+		"subdir/999_nested.up.sql": {Data: []byte("SELECT 1;")},
+	}
+	migs, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migs) != 3 || migs[0].Version != 1 || migs[1].Version != 2 || migs[2].Version != 10 {
+		t.Fatalf("unexpected order %+v", migs)
+	}
+	if migs[1].Down != "DROP TABLE t;" || migs[1].Checksum == "" || migs[1].Checksum == migs[2].Checksum {
+		t.Fatalf("unexpected migration %+v", migs[1])
+	}
+}
+
+func TestLoadRejectsMissingUpAndBadNames(t *testing.T) {
+	if _, err := Load(fstest.MapFS{"001_only_down.down.sql": {Data: []byte("x")}}); err == nil {
+		t.Fatal("expected error for migration without up script")
+	}
+	if _, err := Load(fstest.MapFS{"create_rides.sql": {Data: []byte("x")}}); err == nil {
+		t.Fatal("expected error for unversioned file name")
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migs, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migs {
+		if m.Down == "" {
+			t.Errorf("migration %03d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
@@ -3,43 +3,137 @@ package models
 import "time"
 
 type Coord struct {
-    Lat float64 `json:"lat"`
-    Lon float64 `json:"lon"`
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
 }
 
 type RideRequest struct {
-    RiderID     string `json:"rider_id"`
-    Origin      Coord  `json:"origin"`
-    Destination Coord  `json:"destination"`
+	RiderID     string `json:"rider_id"`
+	Origin      Coord  `json:"origin"`
+	Destination Coord  `json:"destination"`
+	City        string `json:"city,omitempty"`
+	// QuoteID locks the price of a quote from POST /api/v1/rides/quote.
+	QuoteID string `json:"quote_id,omitempty"`
+	// CustomerID and PaymentMethodID are the rider's payment provider
+	// customer and the card the fare is held on.
+	CustomerID      string `json:"customer_id,omitempty"`
+	PaymentMethodID string `json:"payment_method_id,omitempty"`
+	// Fare and PaymentIntentID are set by the API from the quote (or a
+	// fresh estimate) and the card hold, never by clients.
+	Fare            *Fare  `json:"-"`
+	PaymentIntentID string `json:"-"`
 }
 
+// Fare is the price locked for a ride, in minor currency units.
+type Fare struct {
+	Amount          int64   `json:"amount"`
+	Currency        string  `json:"currency"`
+	Surge           float64 `json:"surge_multiplier"`
+	CancellationFee int64   `json:"cancellation_fee,omitempty"` // charged when the rider cancels after a driver accepted
+}
+
+// PaymentStatus tracks the card hold placed for a ride.
+type PaymentStatus string
+
+const (
+	PaymentHeld     PaymentStatus = "held"     // amount authorised, not charged
+	PaymentCaptured PaymentStatus = "captured" // fare or cancellation fee charged
+	PaymentCanceled PaymentStatus = "canceled" // hold released without charge
+	PaymentFailed   PaymentStatus = "failed"   // capture or cancel was rejected; needs follow-up
+)
+
+// DriverStatus is a driver's availability, changed through
+// POST /api/v1/drivers/{id}/status. Location updates leave it untouched.
+type DriverStatus string
+
+const (
+	DriverOnline  DriverStatus = "online"
+	DriverOffline DriverStatus = "offline"
+	DriverBreak   DriverStatus = "break" // signed in but not taking rides
+)
+
 type Driver struct {
-    ID      string  `json:"id"`
-    Loc     Coord   `json:"loc"`
-    Rating  float64 `json:"rating"` // 0..5
-    Online  bool    `json:"online"`
-    Updated time.Time `json:"updated"`
+	ID     string       `json:"id"`
+	Loc    Coord        `json:"loc"`
+	Rating float64      `json:"rating"` // 0..5
+	Status DriverStatus `json:"status,omitempty"`
+	// Online is true when Status is DriverOnline. Messages without a
+	// status may still set it for drivers the geo store does not know yet.
+	Online bool   `json:"online"`
+	City   string `json:"city,omitempty"`
+	// Motion as reported by the device with the location. Heading is nil
+	// when the device did not report one.
+	Heading    *float64  `json:"heading,omitempty"`    // degrees clockwise from north
+	Speed      float64   `json:"speed,omitempty"`      // m/s
+	Accuracy   float64   `json:"accuracy,omitempty"`   // GPS accuracy radius in meters
+	RecordedAt time.Time `json:"recorded_at,omitzero"` // device clock
+	// Seq increases with every ping a device sends. With RecordedAt it lets
+	// the consumer drop duplicate and out-of-order pings.
+	Seq     uint64    `json:"seq,omitempty"`
+	Updated time.Time `json:"updated"`
 }
 
+// MatchOffer is sent to a single driver; it stays pending until the driver
+// answers with a MatchDecision or ExpiresAt passes.
 type MatchOffer struct {
-    DriverID string  `json:"driver_id"`
-    ETA      float64 `json:"eta_seconds"`
-    Cost     float64 `json:"cost"`
+	RideID    string    `json:"ride_id"`
+	DriverID  string    `json:"driver_id"`
+	ETA       float64   `json:"eta_seconds"`
+	Cost      float64   `json:"cost"`
+	Surge     float64   `json:"surge_multiplier"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type MatchDecision struct {
-    RideID   string `json:"ride_id"`
-    DriverID string `json:"driver_id"`
-    Accepted bool   `json:"accepted"`
+	RideID   string `json:"ride_id"`
+	DriverID string `json:"driver_id"`
+	Accepted bool   `json:"accepted"`
 }
 
+// RideStatus is a step in the ride lifecycle; legal moves between statuses
+// are enforced by rides.Lifecycle.
+type RideStatus string
+
+const (
+	StatusRequested RideStatus = "requested" // waiting for a driver to accept an offer
+	StatusAccepted  RideStatus = "accepted"  // driver is heading to the pickup
+	StatusArrived   RideStatus = "arrived"   // driver is waiting at the pickup
+	StatusOngoing   RideStatus = "ongoing"   // rider is on board
+	StatusCompleted RideStatus = "completed"
+	StatusCanceled  RideStatus = "canceled"
+)
+
+// Parties that may cancel a ride.
+const (
+	CanceledByRider  = "rider"
+	CanceledByDriver = "driver"
+	CanceledBySystem = "system" // e.g. every candidate declined or timed out
+)
+
 type Ride struct {
-    ID        string
-    RiderID   string
-    DriverID  string
-    Origin    Coord
-    Destination Coord
-    Status    string // requested, matched, accepted, ongoing, completed, canceled
-    CreatedAt time.Time
-    UpdatedAt time.Time
+	ID          string     `json:"id"`
+	RiderID     string     `json:"rider_id"`
+	DriverID    string     `json:"driver_id,omitempty"`
+	Origin      Coord      `json:"origin"`
+	Destination Coord      `json:"destination"`
+	Status      RideStatus `json:"status"`
+	CanceledBy  string     `json:"canceled_by,omitempty"`
+	Fare        *Fare      `json:"fare,omitempty"`
+	// PaymentIntentID is the provider's ID for the hold placed on request.
+	PaymentIntentID string        `json:"payment_intent_id,omitempty"`
+	PaymentStatus   PaymentStatus `json:"payment_status,omitempty"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+// RideEvent records one ride state or payment status change. Ride is a
+// snapshot taken after the change was applied.
+type RideEvent struct {
+	ID         int64      `json:"id"`
+	RideID     string     `json:"ride_id"`
+	Type       string     `json:"type"` // "ride." + ToStatus, or "ride.payment_" + Ride.PaymentStatus
+	FromStatus RideStatus `json:"from_status,omitempty"`
+	ToStatus   RideStatus `json:"to_status"`
+	Ride       Ride       `json:"ride"`
+	At         time.Time  `json:"at"`
 }
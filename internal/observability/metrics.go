@@ -6,10 +6,25 @@ import (
 )
 
 var (
-	MatchesTotal  = promauto.NewCounter(prometheus.CounterOpts{Namespace: "ride_matching", Name: "matches_total", Help: "Total number of matches"})
-	MatchLatency  = promauto.NewHistogram(prometheus.HistogramOpts{Namespace: "ride_matching", Name: "match_latency_seconds", Help: "Match latency seconds"})
-	DriversOnline = promauto.NewGauge(prometheus.GaugeOpts{Namespace: "ride_matching", Name: "drivers_online", Help: "Number of online drivers"})
+	MatchesTotal   = promauto.NewCounter(prometheus.CounterOpts{Namespace: "ride_matching", Name: "matches_total", Help: "Total number of matches"})
+	MatchLatency   = promauto.NewHistogram(prometheus.HistogramOpts{Namespace: "ride_matching", Name: "match_latency_seconds", Help: "Match latency seconds"})
+	DriversEvicted = promauto.NewCounter(prometheus.CounterOpts{Namespace: "ride_matching", Name: "drivers_evicted_total", Help: "Drivers removed from the geo index after going stale"})
 
+	KafkaProducerQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{Namespace: "ride_matching", Name: "kafka_producer_queue_depth", Help: "Location messages queued by the async Kafka producer and not yet acknowledged"})
+	KafkaPublishLatency     = promauto.NewHistogram(prometheus.HistogramOpts{Namespace: "ride_matching", Name: "kafka_publish_latency_seconds", Help: "Time from publishing a location message until Kafka acknowledged it"})
+	KafkaPublishFailures    = promauto.NewCounterVec(
+		prometheus.CounterOpts{Namespace: "ride_matching", Name: "kafka_publish_failures_total", Help: "Location messages that were not written to Kafka"},
+		[]string{"reason"}, // "queue_full" or "write"
+	)
+	TelemetryFrames = promauto.NewCounterVec(
+		prometheus.CounterOpts{Namespace: "ride_matching", Name: "telemetry_frames_total", Help: "UDP telemetry frames received, by result"},
+		[]string{"result"},
+	)
+
+	DriversOnline = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{Namespace: "ride_matching", Name: "drivers_online", Help: "Number of online drivers"},
+		[]string{"city"},
+	)
 	HTTPRequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{Namespace: "ride_matching", Name: "http_requests_total", Help: "Total HTTP requests handled"},
 		[]string{"method", "path", "status"},
@@ -0,0 +1,94 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Intent is the state of a payment intent held by Fake.
+type Intent struct {
+	ID              string
+	RideID          string
+	CustomerID      string
+	PaymentMethodID string
+	Amount          int64
+	Currency        string
+	Captured        int64
+	Status          string // "requires_capture", "succeeded" or "canceled"
+}
+
+// Fake is an in-memory Provider for development and tests.
+type Fake struct {
+	// FailHolds makes every Hold fail, e.g. to simulate a declined card.
+	FailHolds bool
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	byRide  map[string]string
+	next    int
+}
+
+func NewFake() *Fake {
+	return &Fake{intents: make(map[string]*Intent), byRide: make(map[string]string)}
+}
+
+func (f *Fake) Hold(ctx context.Context, rideID string, amount int64, currency, customerID, paymentMethodID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.FailHolds {
+		return "", fmt.Errorf("card declined")
+	}
+	if id, ok := f.byRide[rideID]; ok {
+		return id, nil
+	}
+	f.next++
+	id := fmt.Sprintf("pi_fake_%d", f.next)
+	f.intents[id] = &Intent{ID: id, RideID: rideID, CustomerID: customerID, PaymentMethodID: paymentMethodID,
+		Amount: amount, Currency: currency, Status: "requires_capture"}
+	f.byRide[rideID] = id
+	return id, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, intentID string, amount int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[intentID]
+	if !ok {
+		return ErrUnknownIntent
+	}
+	if in.Status != "requires_capture" {
+		return fmt.Errorf("payment intent %s is %s", intentID, in.Status)
+	}
+	if amount > in.Amount {
+		return fmt.Errorf("capture of %d exceeds hold of %d", amount, in.Amount)
+	}
+	in.Captured = amount
+	in.Status = "succeeded"
+	return nil
+}
+
+func (f *Fake) Cancel(ctx context.Context, intentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[intentID]
+	if !ok {
+		return ErrUnknownIntent
+	}
+	if in.Status != "requires_capture" {
+		return fmt.Errorf("payment intent %s is %s", intentID, in.Status)
+	}
+	in.Status = "canceled"
+	return nil
+}
+
+// Intent returns a copy of the intent's current state.
+func (f *Fake) Intent(id string) (Intent, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[id]
+	if !ok {
+		return Intent{}, false
+	}
+	return *in, true
+}
@@ -0,0 +1,28 @@
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUnknownIntent is returned by providers for payment intents they never
+// issued.
+var ErrUnknownIntent = errors.New("unknown payment intent")
+
+// ErrNotAuthorised is returned by Hold when the card could not be
+// authorised without the rider, e.g. because the bank asked for 3-D Secure.
+var ErrNotAuthorised = errors.New("payment not authorised")
+
+// Provider places, captures and releases card holds. Amounts are in minor
+// currency units.
+type Provider interface {
+	// Hold authorises amount on the customer's payment method without
+	// charging it and returns the payment intent ID. rideID makes the call
+	// idempotent. It fails unless the amount is held and ready to capture.
+	Hold(ctx context.Context, rideID string, amount int64, currency, customerID, paymentMethodID string) (string, error)
+	// Capture charges amount, which may be less than the hold; the rest is
+	// released.
+	Capture(ctx context.Context, intentID string, amount int64) error
+	// Cancel releases the hold without charging.
+	Cancel(ctx context.Context, intentID string) error
+}
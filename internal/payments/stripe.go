@@ -2,47 +2,69 @@ package payments
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"strings"
 
 	stripe "github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentintent"
+	"github.com/stripe/stripe-go/v74/client"
 )
 
 // StripeClient is a thin wrapper around stripe-go for PaymentIntent hold/capture/cancel flows.
-type StripeClient struct{}
+type StripeClient struct {
+	api *client.API
+}
 
-// NewStripeClient initializes the stripe client with the STRIPE_API_KEY env var.
-func NewStripeClient() *StripeClient {
-	stripe.Key = os.Getenv("STRIPE_API_KEY")
-	return &StripeClient{}
+// NewStripeClient returns a client bound to key; it does not touch the
+// package-level stripe.Key, so several clients can coexist.
+func NewStripeClient(key string) *StripeClient {
+	return &StripeClient{api: client.New(key, nil)}
 }
 
-// Hold creates a PaymentIntent with capture_method=manual to hold funds.
-// It returns the PaymentIntent ID on success.
-func (s *StripeClient) Hold(ctx context.Context, amount int64, currency, customerID string) (string, error) {
+// Hold creates and confirms a PaymentIntent with capture_method=manual to
+// hold funds on the customer's payment method. It returns the PaymentIntent
+// ID once the intent is requires_capture; any other status (such as
+// requires_action) cancels the intent and returns ErrNotAuthorised.
+func (s *StripeClient) Hold(ctx context.Context, rideID string, amount int64, currency, customerID, paymentMethodID string) (string, error) {
+	if paymentMethodID == "" {
+		return "", fmt.Errorf("%w: no payment method", ErrNotAuthorised)
+	}
 	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amount),
-		Currency: stripe.String(currency),
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String(strings.ToLower(currency)),
+		PaymentMethod: stripe.String(paymentMethodID),
+		Confirm:       stripe.Bool(true),
 	}
 	if customerID != "" {
 		params.Customer = stripe.String(customerID)
 	}
 	params.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
-	pi, err := paymentintent.New(params)
+	params.Context = ctx
+	params.SetIdempotencyKey("hold-" + rideID)
+	params.AddMetadata("ride_id", rideID)
+	pi, err := s.api.PaymentIntents.New(params)
 	if err != nil {
 		return "", err
 	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		// nothing is held yet; don't leave the intent waiting on the rider
+		_ = s.Cancel(ctx, pi.ID)
+		return "", fmt.Errorf("%w: payment intent %s is %s", ErrNotAuthorised, pi.ID, pi.Status)
+	}
 	return pi.ID, nil
 }
 
-// Capture finalizes a previously-held PaymentIntent.
-func (s *StripeClient) Capture(ctx context.Context, paymentIntentID string) error {
-	_, err := paymentintent.Capture(paymentIntentID, nil)
+// Capture finalizes a previously-held PaymentIntent for amount.
+func (s *StripeClient) Capture(ctx context.Context, paymentIntentID string, amount int64) error {
+	params := &stripe.PaymentIntentCaptureParams{AmountToCapture: stripe.Int64(amount)}
+	params.Context = ctx
+	_, err := s.api.PaymentIntents.Capture(paymentIntentID, params)
 	return err
 }
 
 // Cancel releases the hold on a PaymentIntent.
 func (s *StripeClient) Cancel(ctx context.Context, paymentIntentID string) error {
-	_, err := paymentintent.Cancel(paymentIntentID, nil)
+	params := &stripe.PaymentIntentCancelParams{}
+	params.Context = ctx
+	_, err := s.api.PaymentIntents.Cancel(paymentIntentID, params)
 	return err
 }
@@ -0,0 +1,81 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// DefaultCity is the fare table entry used for rides outside any configured
+// city.
+const DefaultCity = "default"
+
+// FareModel prices a trip. All amounts are in minor currency units (cents).
+type FareModel struct {
+	Currency        string `json:"currency"` // ISO 4217, e.g. "USD"
+	BaseFare        int64  `json:"base_fare"`
+	PerKm           int64  `json:"per_km"`
+	PerMinute       int64  `json:"per_minute"`
+	MinimumFare     int64  `json:"minimum_fare"`
+	BookingFee      int64  `json:"booking_fee"`
+	CancellationFee int64  `json:"cancellation_fee"` // charged when the rider cancels after a driver accepted
+}
+
+// Price returns the fare for a trip of the given distance and duration.
+// Surge scales the metered fare (after the minimum is applied) but not the
+// booking fee.
+func (f FareModel) Price(meters, seconds, surge float64) int64 {
+	fare := float64(f.BaseFare) + float64(f.PerKm)*meters/1000 + float64(f.PerMinute)*seconds/60
+	fare = math.Max(fare, float64(f.MinimumFare))
+	if surge > 1 {
+		fare *= surge
+	}
+	return int64(math.Round(fare)) + f.BookingFee
+}
+
+func (f FareModel) validate() error {
+	if len(f.Currency) != 3 {
+		return fmt.Errorf("currency must be a 3-letter ISO code, got %q", f.Currency)
+	}
+	if f.BaseFare < 0 || f.PerKm < 0 || f.PerMinute < 0 || f.MinimumFare < 0 || f.BookingFee < 0 || f.CancellationFee < 0 {
+		return fmt.Errorf("amounts must not be negative")
+	}
+	return nil
+}
+
+// FareTable maps a city to its fare model.
+type FareTable map[string]FareModel
+
+// DefaultFares is used when no fare table is configured.
+func DefaultFares() FareTable {
+	return FareTable{
+		DefaultCity: {Currency: "USD", BaseFare: 250, PerKm: 120, PerMinute: 30, MinimumFare: 700, BookingFee: 150, CancellationFee: 500},
+	}
+}
+
+// ParseFareTable decodes a JSON object of city -> FareModel. The table must
+// contain a "default" entry.
+func ParseFareTable(raw string) (FareTable, error) {
+	var t FareTable
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return nil, fmt.Errorf("parse fare table: %w", err)
+	}
+	if _, ok := t[DefaultCity]; !ok {
+		return nil, fmt.Errorf("fare table has no %q entry", DefaultCity)
+	}
+	for city, f := range t {
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("fare table %s: %w", city, err)
+		}
+	}
+	return t, nil
+}
+
+// Lookup returns the fare model for city, falling back to the default
+// entry, together with the city it resolved to.
+func (t FareTable) Lookup(city string) (string, FareModel) {
+	if f, ok := t[city]; ok {
+		return city, f
+	}
+	return DefaultCity, t[DefaultCity]
+}
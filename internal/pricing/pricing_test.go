@@ -0,0 +1,176 @@
+package pricing
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+)
+
+func TestSurgeRisesWithDemandAndDecays(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewSurge(SurgeConfig{MaxMultiplier: 2, Sensitivity: 0.5, Smoothing: 0.5, DriverTTL: time.Minute})
+	s.Now = func() time.Time { return now }
+
+	origin := models.Coord{Lat: 37.7749, Lon: -122.4194}
+	s.ObserveDriver(models.Driver{ID: "d1", Loc: origin, Online: true})
+	for _, id := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		s.RecordRequest(id, origin)
+	}
+	if m := s.Multiplier(origin.Lat, origin.Lon); m != 1 {
+		t.Fatalf("multiplier before first recompute = %v, want 1", m)
+	}
+
+	// 5 requests for 1 driver targets 1+0.5*4 = 3, capped at 2; smoothing
+	// halves the gap on each tick.
+	want := []float64{1.5, 1.75, 1.875}
+	for i, w := range want {
+		s.Recompute()
+		if m := s.Multiplier(origin.Lat, origin.Lon); math.Abs(m-w) > 1e-9 {
+			t.Fatalf("tick %d: multiplier = %v, want %v", i, m, w)
+		}
+	}
+
+	// another cell is unaffected
+	if m := s.Multiplier(40.7128, -74.0060); m != 1 {
+		t.Fatalf("unrelated cell multiplier = %v, want 1", m)
+	}
+
+	for _, id := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		s.ResolveRequest(id)
+	}
+	s.Recompute()
+	if m := s.Multiplier(origin.Lat, origin.Lon); math.Abs(m-1.4375) > 1e-9 {
+		t.Fatalf("multiplier after demand cleared = %v, want 1.4375", m)
+	}
+	for i := 0; i < 20; i++ {
+		s.Recompute()
+	}
+	if cell, m := s.Lookup(origin.Lat, origin.Lon); m != 1 || cell != s.Cell(origin.Lat, origin.Lon) {
+		t.Fatalf("lookup after decay = %s %v, want %s 1", cell, m, s.Cell(origin.Lat, origin.Lon))
+	}
+}
+
+func TestSurgeExpiresStaleDrivers(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewSurge(SurgeConfig{MaxMultiplier: 3, Sensitivity: 1, Smoothing: 1, DriverTTL: time.Minute})
+	s.Now = func() time.Time { return now }
+
+	origin := models.Coord{Lat: 51.5074, Lon: -0.1278}
+	s.ObserveDriver(models.Driver{ID: "d1", Loc: origin, Online: true})
+	s.ObserveDriver(models.Driver{ID: "d2", Loc: origin, Online: true})
+	s.RecordRequest("r1", origin)
+	s.RecordRequest("r2", origin)
+	s.Recompute()
+	if m := s.Multiplier(origin.Lat, origin.Lon); m != 1 {
+		t.Fatalf("balanced cell multiplier = %v, want 1", m)
+	}
+
+	// d2 goes offline and d1 stops reporting: supply falls to zero, which
+	// counts as a single driver.
+	s.ObserveDriver(models.Driver{ID: "d2", Status: models.DriverOffline})
+	now = now.Add(2 * time.Minute)
+	s.Recompute()
+	if m := s.Multiplier(origin.Lat, origin.Lon); m != 2 {
+		t.Fatalf("multiplier with no fresh supply = %v, want 2", m)
+	}
+}
+
+func TestSurgeSupplyFollowsReportedAvailability(t *testing.T) {
+	s := NewSurge(SurgeConfig{MaxMultiplier: 3, Sensitivity: 1, Smoothing: 1})
+	origin := models.Coord{Lat: 51.5074, Lon: -0.1278}
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		s.RecordRequest(id, origin)
+	}
+	// d2 is offline in the geo store, e.g. since before this replica
+	// started, so its pings are not supply
+	s.ObserveDriver(models.Driver{ID: "d1", Loc: origin, Online: true})
+	s.ObserveDriver(models.Driver{ID: "d2", Loc: origin})
+	s.Recompute()
+	if m := s.Multiplier(origin.Lat, origin.Lon); m != 3 {
+		t.Fatalf("multiplier with one available driver = %v, want 3", m)
+	}
+
+	s.ObserveDriver(models.Driver{ID: "d2", Loc: origin, Online: true})
+	s.Recompute()
+	if m := s.Multiplier(origin.Lat, origin.Lon); m != 2 {
+		t.Fatalf("multiplier with two available drivers = %v, want 2", m)
+	}
+
+	s.ObserveDriver(models.Driver{ID: "d2", Loc: origin})
+	s.Recompute()
+	if m := s.Multiplier(origin.Lat, origin.Lon); m != 3 {
+		t.Fatalf("multiplier after d2 became unavailable = %v, want 3", m)
+	}
+}
+
+func TestFareModelPrice(t *testing.T) {
+	f := FareModel{Currency: "USD", BaseFare: 250, PerKm: 120, PerMinute: 30, MinimumFare: 700, BookingFee: 150}
+	// 10km in 20min: 250 + 1200 + 600 = 2050, plus booking fee
+	if got := f.Price(10_000, 1200, 1); got != 2200 {
+		t.Fatalf("price = %d, want 2200", got)
+	}
+	// short trip is lifted to the minimum before surge and fee
+	if got := f.Price(500, 60, 1.5); got != 1200 {
+		t.Fatalf("surged minimum price = %d, want 1200", got)
+	}
+}
+
+func TestParseFareTableRequiresDefault(t *testing.T) {
+	if _, err := ParseFareTable(`{"london":{"currency":"GBP","base_fare":200}}`); err == nil {
+		t.Fatal("expected error for table without default entry")
+	}
+	tbl, err := ParseFareTable(`{"default":{"currency":"USD"},"london":{"currency":"GBP","base_fare":200}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if city, f := tbl.Lookup("london"); city != "london" || f.Currency != "GBP" {
+		t.Fatalf("lookup london = %s %+v", city, f)
+	}
+	if city, f := tbl.Lookup("paris"); city != DefaultCity || f.Currency != "USD" {
+		t.Fatalf("lookup paris = %s %+v", city, f)
+	}
+}
+
+type fixedSurge float64
+
+func (f fixedSurge) Multiplier(lat, lon float64) float64 { return float64(f) }
+
+func TestQuoteSignAndVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	q := &Quoter{Fares: DefaultFares(), SpeedMps: 10, Surge: fixedSurge(1.5), Key: []byte("secret"), TTL: time.Minute, Now: func() time.Time { return now }}
+	req := models.RideRequest{RiderID: "r1", Origin: models.Coord{Lat: 37.7749, Lon: -122.4194}, Destination: models.Coord{Lat: 37.7929, Lon: -122.3969}}
+
+	quote, err := q.Quote(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Currency != "USD" || quote.Surge != 1.5 || quote.Amount <= 0 {
+		t.Fatalf("unexpected quote %+v", quote)
+	}
+
+	got, err := q.Verify(quote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount != quote.Amount || got.RiderID != "r1" || got.Origin != req.Origin {
+		t.Fatalf("verified quote %+v differs from %+v", got, quote)
+	}
+
+	body, sig, _ := strings.Cut(quote.ID, ".")
+	if _, err := q.Verify(body + "x." + sig); !errors.Is(err, ErrInvalidQuote) {
+		t.Fatalf("tampered quote: err = %v, want ErrInvalidQuote", err)
+	}
+	other := &Quoter{Fares: DefaultFares(), Key: []byte("other"), Now: q.Now}
+	if _, err := other.Verify(quote.ID); !errors.Is(err, ErrInvalidQuote) {
+		t.Fatalf("foreign key: err = %v, want ErrInvalidQuote", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := q.Verify(quote.ID); !errors.Is(err, ErrQuoteExpired) {
+		t.Fatalf("expired quote: err = %v, want ErrQuoteExpired", err)
+	}
+}
@@ -0,0 +1,165 @@
+package pricing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/example/ride-matching/internal/eta"
+	"github.com/example/ride-matching/internal/models"
+)
+
+const defaultQuoteTTL = 2 * time.Minute
+
+var (
+	// ErrInvalidQuote is returned for quote IDs that are malformed or whose
+	// signature does not verify.
+	ErrInvalidQuote = errors.New("invalid quote")
+	// ErrQuoteExpired is returned for genuine quotes past their expiry.
+	ErrQuoteExpired = errors.New("quote expired")
+)
+
+// Quote is an upfront price for a trip. ID is a signed token carrying the
+// quote itself, so any replica holding the signing key can verify it
+// without shared state.
+type Quote struct {
+	ID              string       `json:"quote_id"`
+	RiderID         string       `json:"rider_id"`
+	City            string       `json:"city"`
+	Origin          models.Coord `json:"origin"`
+	Destination     models.Coord `json:"destination"`
+	DistanceMeters  float64      `json:"distance_meters"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Surge           float64      `json:"surge_multiplier"`
+	Amount          int64        `json:"amount"`
+	Currency        string       `json:"currency"`
+	CancellationFee int64        `json:"cancellation_fee"`
+	ExpiresAt       time.Time    `json:"expires_at"`
+}
+
+// Fare returns the price to lock on a ride booked with this quote.
+func (q Quote) Fare() *models.Fare {
+	return &models.Fare{Amount: q.Amount, Currency: q.Currency, Surge: q.Surge, CancellationFee: q.CancellationFee}
+}
+
+// SurgeSource supplies the multiplier at a pickup point; *Surge implements
+// it.
+type SurgeSource interface {
+	Multiplier(lat, lon float64) float64
+}
+
+// Quoter prices trips from a fare table, the current surge and a route
+// estimate, and signs quotes with an HMAC key.
+type Quoter struct {
+	Fares    FareTable
+	ETA      eta.Client // optional routing engine; haversine/SpeedMps otherwise
+	SpeedMps float64
+	Surge    SurgeSource // optional
+	Key      []byte
+	TTL      time.Duration
+	// Now is the clock used for expiry; tests replace it for determinism.
+	Now func() time.Time
+}
+
+// Estimate prices the trip at current rates without signing it.
+func (q *Quoter) Estimate(req models.RideRequest) Quote {
+	city, fare := q.Fares.Lookup(req.City)
+	meters, seconds := q.route(req.Origin, req.Destination)
+	surge := 1.0
+	if q.Surge != nil {
+		surge = q.Surge.Multiplier(req.Origin.Lat, req.Origin.Lon)
+	}
+	return Quote{
+		RiderID:         req.RiderID,
+		City:            city,
+		Origin:          req.Origin,
+		Destination:     req.Destination,
+		DistanceMeters:  math.Round(meters),
+		DurationSeconds: math.Round(seconds),
+		Surge:           surge,
+		Amount:          fare.Price(meters, seconds, surge),
+		Currency:        fare.Currency,
+		CancellationFee: fare.CancellationFee,
+	}
+}
+
+// Quote prices the trip and returns it with a signed ID valid for TTL.
+func (q *Quoter) Quote(req models.RideRequest) (Quote, error) {
+	quote := q.Estimate(req)
+	quote.ExpiresAt = q.now().Add(q.ttl()).UTC().Truncate(time.Second)
+	payload, err := json.Marshal(quote)
+	if err != nil {
+		return Quote{}, err
+	}
+	enc := base64.RawURLEncoding
+	quote.ID = enc.EncodeToString(payload) + "." + enc.EncodeToString(q.sign(payload))
+	return quote, nil
+}
+
+// Verify checks a quote ID's signature and expiry and returns the quote.
+func (q *Quoter) Verify(id string) (Quote, error) {
+	enc := base64.RawURLEncoding
+	body, sig, ok := strings.Cut(id, ".")
+	if !ok {
+		return Quote{}, ErrInvalidQuote
+	}
+	payload, err := enc.DecodeString(body)
+	if err != nil {
+		return Quote{}, ErrInvalidQuote
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, q.sign(payload)) {
+		return Quote{}, ErrInvalidQuote
+	}
+	var quote Quote
+	if err := json.Unmarshal(payload, &quote); err != nil {
+		return Quote{}, ErrInvalidQuote
+	}
+	if !q.now().Before(quote.ExpiresAt) {
+		return Quote{}, ErrQuoteExpired
+	}
+	quote.ID = id
+	return quote, nil
+}
+
+func (q *Quoter) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, q.Key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// route estimates distance and duration, preferring the routing engine and
+// falling back to the great-circle distance at SpeedMps.
+func (q *Quoter) route(from, to models.Coord) (float64, float64) {
+	if r, ok := q.ETA.(eta.Router); ok {
+		if meters, seconds, err := r.Route(from, to); err == nil {
+			return meters, seconds
+		}
+	}
+	meters := eta.DistanceMeters(from, to)
+	if q.ETA != nil {
+		if seconds, err := q.ETA.EstimateSeconds(from, to); err == nil {
+			return meters, seconds
+		}
+	}
+	return meters, eta.EstimateSeconds(from, to, q.SpeedMps)
+}
+
+func (q *Quoter) ttl() time.Duration {
+	if q.TTL <= 0 {
+		return defaultQuoteTTL
+	}
+	return q.TTL
+}
+
+func (q *Quoter) now() time.Time {
+	if q.Now != nil {
+		return q.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,199 @@
+// Package pricing computes dynamic surge multipliers and fares.
+package pricing
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/example/ride-matching/internal/geo"
+	"github.com/example/ride-matching/internal/models"
+)
+
+// SurgeConfig tunes the surge engine. Zero values fall back to defaults.
+type SurgeConfig struct {
+	CellPrecision int     // geohash length of a pricing cell
+	MaxMultiplier float64 // hard cap on the multiplier
+	// Sensitivity scales how far the multiplier moves per unit of excess
+	// demand: target = 1 + Sensitivity*(requests/drivers - 1).
+	Sensitivity float64
+	// Smoothing is the weight of the newest target in the exponential moving
+	// average, in (0, 1]; 1 disables smoothing.
+	Smoothing  float64
+	DriverTTL  time.Duration // drivers not seen for this long stop counting as supply
+	RequestTTL time.Duration // open requests older than this stop counting as demand
+}
+
+func (c SurgeConfig) withDefaults() SurgeConfig {
+	if c.CellPrecision <= 0 {
+		c.CellPrecision = 6
+	}
+	if c.MaxMultiplier < 1 {
+		c.MaxMultiplier = 3
+	}
+	if c.Sensitivity <= 0 {
+		c.Sensitivity = 0.5
+	}
+	if c.Smoothing <= 0 || c.Smoothing > 1 {
+		c.Smoothing = 0.3
+	}
+	if c.DriverTTL <= 0 {
+		c.DriverTTL = 2 * time.Minute
+	}
+	if c.RequestTTL <= 0 {
+		c.RequestTTL = 10 * time.Minute
+	}
+	return c
+}
+
+type sighting struct {
+	cell string
+	at   time.Time
+}
+
+// Surge tracks open ride requests and online drivers per geohash cell and
+// turns their ratio into a smoothed, capped price multiplier. Multipliers
+// only change in Recompute, which Run calls on a ticker, so reads between
+// ticks are stable.
+type Surge struct {
+	cfg SurgeConfig
+	// Now is the clock used for TTLs; tests replace it for determinism.
+	Now func() time.Time
+
+	mu          sync.RWMutex
+	drivers     map[string]sighting
+	requests    map[string]sighting
+	multipliers map[string]float64
+}
+
+func NewSurge(cfg SurgeConfig) *Surge {
+	return &Surge{
+		cfg:         cfg.withDefaults(),
+		Now:         time.Now,
+		drivers:     make(map[string]sighting),
+		requests:    make(map[string]sighting),
+		multipliers: make(map[string]float64),
+	}
+}
+
+// Cell returns the pricing cell containing the point.
+func (s *Surge) Cell(lat, lon float64) string {
+	return geo.Geohash(lat, lon, s.cfg.CellPrecision)
+}
+
+// ObserveDriver feeds a location ping or a status change into supply. A ping
+// (empty Status) counts the driver in its current cell when d.Online is set
+// and drops it otherwise, so callers pass the availability the geo store
+// reported for the ping. Going offline or on a break drops the driver right
+// away; going online counts it again from its next ping.
+func (s *Surge) ObserveDriver(d models.Driver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case d.Status == "" && d.Online:
+		s.drivers[d.ID] = sighting{cell: s.Cell(d.Loc.Lat, d.Loc.Lon), at: s.Now()}
+	case d.Status != models.DriverOnline:
+		delete(s.drivers, d.ID)
+	}
+}
+
+// RecordRequest counts a ride request as open demand at its pickup point.
+func (s *Surge) RecordRequest(rideID string, origin models.Coord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[rideID] = sighting{cell: s.Cell(origin.Lat, origin.Lon), at: s.Now()}
+}
+
+// ResolveRequest stops counting a request once it was accepted or canceled.
+func (s *Surge) ResolveRequest(rideID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.requests, rideID)
+}
+
+// Lookup returns the cell for the point and its current multiplier.
+func (s *Surge) Lookup(lat, lon float64) (string, float64) {
+	cell := s.Cell(lat, lon)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m, ok := s.multipliers[cell]; ok {
+		return cell, m
+	}
+	return cell, 1
+}
+
+// Multiplier returns the current multiplier at the point (1 when no surge).
+func (s *Surge) Multiplier(lat, lon float64) float64 {
+	_, m := s.Lookup(lat, lon)
+	return m
+}
+
+// Recompute drops expired sightings and moves every cell's multiplier one
+// smoothing step towards its supply/demand target.
+func (s *Surge) Recompute() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.Now()
+
+	supply := make(map[string]int)
+	for id, d := range s.drivers {
+		if now.Sub(d.at) > s.cfg.DriverTTL {
+			delete(s.drivers, id)
+			continue
+		}
+		supply[d.cell]++
+	}
+	demand := make(map[string]int)
+	for id, r := range s.requests {
+		if now.Sub(r.at) > s.cfg.RequestTTL {
+			delete(s.requests, id)
+			continue
+		}
+		demand[r.cell]++
+	}
+
+	next := make(map[string]float64, len(demand))
+	for cell, want := range demand {
+		next[cell] = s.step(s.multipliers[cell], s.target(want, supply[cell]))
+	}
+	// cells without demand decay back to 1
+	for cell, prev := range s.multipliers {
+		if _, ok := next[cell]; ok {
+			continue
+		}
+		if m := s.step(prev, 1); m > 1.001 {
+			next[cell] = m
+		}
+	}
+	s.multipliers = next
+}
+
+func (s *Surge) target(demand, supply int) float64 {
+	ratio := float64(demand) / math.Max(float64(supply), 1)
+	if ratio <= 1 {
+		return 1
+	}
+	return math.Min(1+s.cfg.Sensitivity*(ratio-1), s.cfg.MaxMultiplier)
+}
+
+func (s *Surge) step(prev, target float64) float64 {
+	if prev == 0 {
+		prev = 1
+	}
+	return prev + s.cfg.Smoothing*(target-prev)
+}
+
+// Run recomputes multipliers every interval until ctx is canceled.
+func (s *Surge) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.Recompute()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package rides
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/payments"
+	"github.com/example/ride-matching/internal/storage"
+)
+
+type fakeOffers struct{ canceled []string }
+
+func (f *fakeOffers) CancelOffer(rideID string) bool {
+	f.canceled = append(f.canceled, rideID)
+	return true
+}
+
+func newRide(t *testing.T, store storage.TripStore, id string) {
+	t.Helper()
+	now := time.Now()
+	if err := store.SaveRide(&models.Ride{ID: id, RiderID: "r1", Status: models.StatusRequested, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecycleHappyPath(t *testing.T) {
+	store := storage.NewMemoryStore()
+	s := &Service{Store: store}
+	newRide(t, store, "ride1")
+
+	if _, err := s.Start("ride1", "d1"); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected illegal transition before accept, got %v", err)
+	}
+	if _, err := s.Accept("ride1", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Arrive("ride1", "d2"); !errors.Is(err, ErrNotParticipant) {
+		t.Fatalf("expected other driver to be rejected, got %v", err)
+	}
+	for _, step := range []func(string, string) (*models.Ride, error){s.Arrive, s.Start, s.Complete} {
+		if _, err := step("ride1", "d1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, _ := store.GetRide("ride1")
+	if r.Status != models.StatusCompleted {
+		t.Fatalf("expected completed, got %s", r.Status)
+	}
+	var terr *TransitionError
+	if _, err := s.Cancel("ride1", models.CanceledByRider, "r1"); !errors.As(err, &terr) || terr.From != models.StatusCompleted {
+		t.Fatalf("expected completed ride to reject cancel, got %v", err)
+	}
+}
+
+func TestRiderCancelStopsPendingOffers(t *testing.T) {
+	store := storage.NewMemoryStore()
+	offers := &fakeOffers{}
+	s := &Service{Store: store, Offers: offers}
+	newRide(t, store, "ride1")
+
+	if _, err := s.Cancel("ride1", models.CanceledByRider, "someone-else"); !errors.Is(err, ErrNotParticipant) {
+		t.Fatalf("expected ErrNotParticipant, got %v", err)
+	}
+	r, err := s.Cancel("ride1", models.CanceledByRider, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != models.StatusCanceled || r.CanceledBy != models.CanceledByRider {
+		t.Fatalf("unexpected ride %+v", r)
+	}
+	if len(offers.canceled) != 1 {
+		t.Fatalf("expected offer cascade to be stopped, got %v", offers.canceled)
+	}
+}
+
+func TestPaymentsSettleWithRide(t *testing.T) {
+	store := storage.NewMemoryStore()
+	pay := payments.NewFake()
+	s := &Service{Store: store, Payments: pay}
+	hold := func(id string) string {
+		t.Helper()
+		intent, err := pay.Hold(context.Background(), id, 2000, "USD", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		r := &models.Ride{ID: id, RiderID: "r1", Status: models.StatusRequested, CreatedAt: now, UpdatedAt: now,
+			Fare: &models.Fare{Amount: 2000, Currency: "USD", CancellationFee: 500}, PaymentIntentID: intent, PaymentStatus: models.PaymentHeld}
+		if err := store.SaveRide(r); err != nil {
+			t.Fatal(err)
+		}
+		return intent
+	}
+	check := func(rideID, intent string, status models.PaymentStatus, captured int64) {
+		t.Helper()
+		r, _ := store.GetRide(rideID)
+		if r.PaymentStatus != status {
+			t.Fatalf("%s: payment status = %s, want %s", rideID, r.PaymentStatus, status)
+		}
+		in, _ := pay.Intent(intent)
+		if in.Captured != captured {
+			t.Fatalf("%s: captured %d, want %d", rideID, in.Captured, captured)
+		}
+	}
+
+	completed := hold("completed")
+	_, _ = s.Accept("completed", "d1")
+	for _, step := range []func(string, string) (*models.Ride, error){s.Arrive, s.Start, s.Complete} {
+		if _, err := step("completed", "d1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("completed", completed, models.PaymentCaptured, 2000)
+
+	early := hold("early")
+	if _, err := s.Cancel("early", models.CanceledByRider, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	check("early", early, models.PaymentCanceled, 0)
+
+	late := hold("late")
+	_, _ = s.Accept("late", "d1")
+	if _, err := s.Cancel("late", models.CanceledByRider, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	check("late", late, models.PaymentCaptured, 500)
+
+	byDriver := hold("by-driver")
+	_, _ = s.Accept("by-driver", "d1")
+	if _, err := s.Cancel("by-driver", models.CanceledByDriver, "d1"); err != nil {
+		t.Fatal(err)
+	}
+	check("by-driver", byDriver, models.PaymentCanceled, 0)
+}
@@ -0,0 +1,175 @@
+package rides
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+	"github.com/example/ride-matching/internal/payments"
+	"github.com/example/ride-matching/internal/storage"
+)
+
+const paymentTimeout = 10 * time.Second
+
+// ErrNotParticipant is returned when the acting rider or driver is not part
+// of the ride.
+var ErrNotParticipant = errors.New("actor is not part of this ride")
+
+// Releaser frees a driver reserved for a ride.
+type Releaser interface {
+	Release(driverID, holder string) error
+}
+
+// OfferCanceler stops an outstanding offer cascade for a ride. It reports
+// whether an offer was pending.
+type OfferCanceler interface {
+	CancelOffer(rideID string) bool
+}
+
+// Service applies lifecycle transitions and persists them via TripStore.
+// Transitions are serialised so concurrent requests for the same ride (for
+// instance an accept racing a rider cancel) observe each other's writes.
+type Service struct {
+	Store  storage.TripStore
+	Geo    Releaser      // optional; frees the driver when a trip ends
+	Offers OfferCanceler // optional; stops matching when a requested ride is canceled
+	// Payments settles the hold placed on request: the fare is captured on
+	// completion, and the hold released (or a fee charged) on cancel.
+	Payments payments.Provider // optional
+
+	mu sync.Mutex
+}
+
+// Accept assigns the driver to a requested ride.
+func (s *Service) Accept(rideID, driverID string) (*models.Ride, error) {
+	return s.transition(rideID, models.StatusAccepted, func(r *models.Ride) error {
+		r.DriverID = driverID
+		return nil
+	})
+}
+
+// Arrive marks the driver as waiting at the pickup point.
+func (s *Service) Arrive(rideID, driverID string) (*models.Ride, error) {
+	return s.transition(rideID, models.StatusArrived, driverCheck(driverID))
+}
+
+// Start marks the rider as on board.
+func (s *Service) Start(rideID, driverID string) (*models.Ride, error) {
+	return s.transition(rideID, models.StatusOngoing, driverCheck(driverID))
+}
+
+// Complete finishes the trip, frees the driver and captures the fare.
+func (s *Service) Complete(rideID, driverID string) (*models.Ride, error) {
+	r, err := s.transition(rideID, models.StatusCompleted, driverCheck(driverID))
+	if err == nil {
+		s.release(r)
+		var amount int64
+		if r.Fare != nil {
+			amount = r.Fare.Amount
+		}
+		s.settle(r, amount)
+	}
+	return r, err
+}
+
+// Cancel cancels the ride on behalf of by (models.CanceledByRider, Driver or
+// System). actorID must match the ride's rider or driver respectively. A
+// rider canceling after a driver accepted pays the ride's cancellation fee;
+// every other cancel releases the hold.
+func (s *Service) Cancel(rideID, by, actorID string) (*models.Ride, error) {
+	var from models.RideStatus
+	r, err := s.transition(rideID, models.StatusCanceled, func(r *models.Ride) error {
+		switch by {
+		case models.CanceledByRider:
+			if r.RiderID != actorID {
+				return ErrNotParticipant
+			}
+		case models.CanceledByDriver:
+			if r.DriverID == "" || r.DriverID != actorID {
+				return ErrNotParticipant
+			}
+		}
+		if r.Status == models.StatusRequested && s.Offers != nil {
+			s.Offers.CancelOffer(r.ID)
+		}
+		from = r.Status
+		r.CanceledBy = by
+		return nil
+	})
+	if err == nil {
+		s.release(r)
+		var fee int64
+		if by == models.CanceledByRider && from != models.StatusRequested && r.Fare != nil {
+			fee = min(r.Fare.CancellationFee, r.Fare.Amount)
+		}
+		s.settle(r, fee)
+	}
+	return r, err
+}
+
+// transition loads the ride, validates the move, runs check (which may
+// mutate the ride or veto the change) and persists the result.
+func (s *Service) transition(rideID string, to models.RideStatus, check func(r *models.Ride) error) (*models.Ride, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.Store.GetRide(rideID)
+	if err != nil {
+		return nil, err
+	}
+	if !Lifecycle.Can(r.Status, to) {
+		return nil, &TransitionError{From: r.Status, To: to}
+	}
+	if err := check(r); err != nil {
+		return nil, err
+	}
+	if err := Lifecycle.Apply(r, to, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.Store.UpdateRide(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *Service) release(r *models.Ride) {
+	if s.Geo != nil && r.DriverID != "" {
+		_ = s.Geo.Release(r.DriverID, r.ID)
+	}
+}
+
+// settle captures amount from the ride's hold, or releases the hold when
+// amount is zero, and records the outcome on the ride. A failed payment call
+// does not undo the transition; the ride is marked PaymentFailed instead.
+func (s *Service) settle(r *models.Ride, amount int64) {
+	if s.Payments == nil || r.PaymentIntentID == "" || r.PaymentStatus != models.PaymentHeld {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), paymentTimeout)
+	defer cancel()
+	var err error
+	if amount > 0 {
+		err = s.Payments.Capture(ctx, r.PaymentIntentID, amount)
+		r.PaymentStatus = models.PaymentCaptured
+	} else {
+		err = s.Payments.Cancel(ctx, r.PaymentIntentID)
+		r.PaymentStatus = models.PaymentCanceled
+	}
+	if err != nil {
+		r.PaymentStatus = models.PaymentFailed
+	}
+	r.UpdatedAt = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.Store.UpdateRide(r)
+}
+
+func driverCheck(driverID string) func(r *models.Ride) error {
+	return func(r *models.Ride) error {
+		if r.DriverID != driverID {
+			return ErrNotParticipant
+		}
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package rides
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+)
+
+// ErrIllegalTransition is wrapped by every TransitionError.
+var ErrIllegalTransition = errors.New("illegal ride state transition")
+
+// TransitionError reports a status change the lifecycle does not allow.
+type TransitionError struct {
+	From models.RideStatus
+	To   models.RideStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("ride cannot move from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error { return ErrIllegalTransition }
+
+// Machine maps every status to the statuses it may move to. Statuses
+// without an entry are terminal.
+type Machine map[models.RideStatus][]models.RideStatus
+
+// Lifecycle is the ride state machine shared by the matcher and the API.
+var Lifecycle = Machine{
+	models.StatusRequested: {models.StatusAccepted, models.StatusCanceled},
+	models.StatusAccepted:  {models.StatusArrived, models.StatusCanceled},
+	models.StatusArrived:   {models.StatusOngoing, models.StatusCanceled},
+	models.StatusOngoing:   {models.StatusCompleted},
+}
+
+// Can reports whether from -> to is a legal transition.
+func (m Machine) Can(from, to models.RideStatus) bool {
+	for _, next := range m[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply moves the ride to status to, or returns a *TransitionError and
+// leaves the ride untouched.
+func (m Machine) Apply(r *models.Ride, to models.RideStatus, at time.Time) error {
+	if !m.Can(r.Status, to) {
+		return &TransitionError{From: r.Status, To: to}
+	}
+	r.Status = to
+	r.UpdatedAt = at
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+)
+
+// OutboxMessage is a ride event waiting to be published. Key is the ride ID
+// so every event of a ride lands on the same Kafka partition.
+type OutboxMessage struct {
+	ID        int64
+	Key       string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// Outbox is implemented by stores that write an outbox row in the same
+// transaction as every ride state change.
+type Outbox interface {
+	// DrainOutbox hands up to limit unsent messages, oldest first, to publish
+	// and marks them sent only if publish returns nil, so delivery is
+	// at-least-once. It returns how many messages were published.
+	DrainOutbox(ctx context.Context, limit int, publish func([]OutboxMessage) error) (int, error)
+}
+
+// newRideEvent builds the event for a ride moving from -> r.Status, or,
+// when only the payment moved (fromPayment -> r.PaymentStatus, e.g. the
+// fare was captured), a "ride.payment_<status>" event. It returns false when
+// neither changed.
+func newRideEvent(from models.RideStatus, fromPayment models.PaymentStatus, r *models.Ride, at time.Time) (models.RideEvent, bool) {
+	typ := "ride." + string(r.Status)
+	if from == r.Status {
+		if fromPayment == r.PaymentStatus {
+			return models.RideEvent{}, false
+		}
+		typ = "ride.payment_" + string(r.PaymentStatus)
+	}
+	return models.RideEvent{
+		RideID:     r.ID,
+		Type:       typ,
+		FromStatus: from,
+		ToStatus:   r.Status,
+		Ride:       *r,
+		At:         at,
+	}, true
+}
+
+func (m *MemoryStore) recordEvent(e models.RideEvent) {
+	m.nextEventID++
+	e.ID = m.nextEventID
+	m.events = append(m.events, e)
+	payload, _ := json.Marshal(e)
+	m.outbox = append(m.outbox, OutboxMessage{ID: e.ID, Key: e.RideID, Payload: payload, CreatedAt: e.At})
+}
+
+// Events returns every ride event recorded so far, oldest first.
+func (m *MemoryStore) Events() []models.RideEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]models.RideEvent(nil), m.events...)
+}
+
+func (m *MemoryStore) DrainOutbox(ctx context.Context, limit int, publish func([]OutboxMessage) error) (int, error) {
+	m.drainMu.Lock()
+	defer m.drainMu.Unlock()
+	m.mu.Lock()
+	n := min(limit, len(m.outbox))
+	batch := append([]OutboxMessage(nil), m.outbox[:n]...)
+	m.mu.Unlock()
+	if n == 0 {
+		return 0, nil
+	}
+	if err := publish(batch); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	m.outbox = m.outbox[n:]
+	m.mu.Unlock()
+	return n, nil
+}
@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/example/ride-matching/internal/models"
 )
@@ -25,13 +30,220 @@ func NewPostgresStore(dsn string) (*PostgresStore, error) {
 	return &PostgresStore{db: db}, nil
 }
 
+// SaveRide inserts the ride together with its first ride_events and
+// ride_outbox rows in a single transaction.
 func (p *PostgresStore) SaveRide(r *models.Ride) error {
-	_, err := p.db.Exec(`INSERT INTO rides(id, rider_id, driver_id, origin_lat, origin_lon, dest_lat, dest_lon, status, created_at, updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
-		r.ID, r.RiderID, r.DriverID, r.Origin.Lat, r.Origin.Lon, r.Destination.Lat, r.Destination.Lon, r.Status, r.CreatedAt, r.UpdatedAt)
-	return err
+	return p.inTx(func(tx *sql.Tx) error {
+		var fareAmount, cancelFee sql.NullInt64
+		var fareCurrency sql.NullString
+		var surge sql.NullFloat64
+		if r.Fare != nil {
+			fareAmount = sql.NullInt64{Int64: r.Fare.Amount, Valid: true}
+			fareCurrency = sql.NullString{String: r.Fare.Currency, Valid: true}
+			surge = sql.NullFloat64{Float64: r.Fare.Surge, Valid: true}
+			cancelFee = sql.NullInt64{Int64: r.Fare.CancellationFee, Valid: true}
+		}
+		if _, err := tx.Exec(`INSERT INTO rides(id, rider_id, driver_id, origin_lat, origin_lon, dest_lat, dest_lon, status, fare_amount, fare_currency, surge_multiplier, cancellation_fee, payment_intent_id, payment_status, created_at, updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)`,
+			r.ID, r.RiderID, r.DriverID, r.Origin.Lat, r.Origin.Lon, r.Destination.Lat, r.Destination.Lon, r.Status, fareAmount, fareCurrency, surge, cancelFee, nullString(r.PaymentIntentID), nullString(string(r.PaymentStatus)), r.CreatedAt, r.UpdatedAt); err != nil {
+			return err
+		}
+		return p.recordEvent(tx, "", "", r)
+	})
 }
 
+// UpdateRide updates the ride and, when its status or payment status
+// changed, records the change in ride_events and ride_outbox within the same
+// transaction.
 func (p *PostgresStore) UpdateRide(r *models.Ride) error {
-	_, err := p.db.Exec(`UPDATE rides SET driver_id=$1, status=$2, updated_at=$3 WHERE id=$4`, r.DriverID, r.Status, time.Now(), r.ID)
+	return p.inTx(func(tx *sql.Tx) error {
+		var from models.RideStatus
+		var fromPayment sql.NullString
+		err := tx.QueryRow(`SELECT status, payment_status FROM rides WHERE id=$1 FOR UPDATE`, r.ID).Scan(&from, &fromPayment)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE rides SET driver_id=$1, status=$2, canceled_by=$3, payment_status=$4, updated_at=$5 WHERE id=$6`, r.DriverID, r.Status, r.CanceledBy, nullString(string(r.PaymentStatus)), r.UpdatedAt, r.ID); err != nil {
+			return err
+		}
+		return p.recordEvent(tx, from, models.PaymentStatus(fromPayment.String), r)
+	})
+}
+
+func (p *PostgresStore) recordEvent(tx *sql.Tx, from models.RideStatus, fromPayment models.PaymentStatus, r *models.Ride) error {
+	e, ok := newRideEvent(from, fromPayment, r, time.Now())
+	if !ok {
+		return nil
+	}
+	snapshot, err := json.Marshal(e.Ride)
+	if err != nil {
+		return err
+	}
+	if err := tx.QueryRow(`INSERT INTO ride_events(ride_id, type, from_status, to_status, ride, created_at) VALUES($1,$2,$3,$4,$5,$6) RETURNING id`,
+		e.RideID, e.Type, e.FromStatus, e.ToStatus, snapshot, e.At).Scan(&e.ID); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO ride_outbox(event_id, key, payload, created_at) VALUES($1,$2,$3,$4)`, e.ID, e.RideID, payload, e.At)
 	return err
 }
+
+// DrainOutbox locks a batch of unsent rows with SKIP LOCKED so several
+// relays can run side by side, publishes them and marks them sent in the
+// same transaction. A crash after publishing but before commit re-sends the
+// batch, which is the at-least-once contract consumers must tolerate.
+func (p *PostgresStore) DrainOutbox(ctx context.Context, limit int, publish func([]OutboxMessage) error) (int, error) {
+	var n int
+	err := p.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT id, key, payload, created_at FROM ride_outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, limit)
+		if err != nil {
+			return err
+		}
+		var batch []OutboxMessage
+		for rows.Next() {
+			var m OutboxMessage
+			if err := rows.Scan(&m.ID, &m.Key, &m.Payload, &m.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := publish(batch); err != nil {
+			return err
+		}
+		ids := make([]int64, len(batch))
+		for i, m := range batch {
+			ids[i] = m.ID
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE ride_outbox SET sent_at = now() WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+			return err
+		}
+		n = len(batch)
+		return nil
+	})
+	return n, err
+}
+
+func (p *PostgresStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+const rideColumns = `id, rider_id, driver_id, origin_lat, origin_lon, dest_lat, dest_lon, status, canceled_by, fare_amount, fare_currency, surge_multiplier, cancellation_fee, payment_intent_id, payment_status, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRide(row rowScanner) (*models.Ride, error) {
+	var r models.Ride
+	var driverID, canceledBy, fareCurrency, intentID, paymentStatus sql.NullString
+	var fareAmount, cancelFee sql.NullInt64
+	var surge sql.NullFloat64
+	if err := row.Scan(&r.ID, &r.RiderID, &driverID, &r.Origin.Lat, &r.Origin.Lon, &r.Destination.Lat, &r.Destination.Lon, &r.Status, &canceledBy,
+		&fareAmount, &fareCurrency, &surge, &cancelFee, &intentID, &paymentStatus, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	r.DriverID = driverID.String
+	r.CanceledBy = canceledBy.String
+	if fareAmount.Valid {
+		r.Fare = &models.Fare{Amount: fareAmount.Int64, Currency: fareCurrency.String, Surge: surge.Float64, CancellationFee: cancelFee.Int64}
+	}
+	r.PaymentIntentID = intentID.String
+	r.PaymentStatus = models.PaymentStatus(paymentStatus.String)
+	return &r, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (p *PostgresStore) GetRide(id string) (*models.Ride, error) {
+	r, err := scanRide(p.db.QueryRow(`SELECT `+rideColumns+` FROM rides WHERE id=$1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return r, err
+}
+
+func (p *PostgresStore) ListRidesByRider(riderID string, page PageRequest) (Page, error) {
+	return p.listRides([]string{"rider_id = $%d"}, []any{riderID}, page)
+}
+
+func (p *PostgresStore) ListRidesByDriver(driverID string, page PageRequest) (Page, error) {
+	return p.listRides([]string{"driver_id = $%d"}, []any{driverID}, page)
+}
+
+func (p *PostgresStore) ListRidesByStatus(q StatusQuery, page PageRequest) (Page, error) {
+	conds, args := []string{"status = $%d"}, []any{q.Status}
+	if !q.From.IsZero() {
+		conds, args = append(conds, "created_at >= $%d"), append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		conds, args = append(conds, "created_at < $%d"), append(args, q.To)
+	}
+	return p.listRides(conds, args, page)
+}
+
+// listRides runs a keyset-paginated query, newest first. The %d verbs in
+// conds are numbered in order into positional parameters matching args.
+func (p *PostgresStore) listRides(conds []string, args []any, page PageRequest) (Page, error) {
+	c, err := page.cursor()
+	if err != nil {
+		return Page{}, err
+	}
+	if c != nil {
+		conds = append(conds, "(created_at, id) < ($%d, $%d)")
+		args = append(args, c.createdAt, c.id)
+	}
+	where := make([]string, len(conds))
+	n := 0
+	for i, cond := range conds {
+		verbs := strings.Count(cond, "%d")
+		idx := make([]any, verbs)
+		for j := range idx {
+			n++
+			idx[j] = n
+		}
+		where[i] = fmt.Sprintf(cond, idx...)
+	}
+	limit := page.limit()
+	query := fmt.Sprintf(`SELECT %s FROM rides WHERE %s ORDER BY created_at DESC, id DESC LIMIT %d`, rideColumns, strings.Join(where, " AND "), limit+1)
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+	out := Page{Rides: make([]*models.Ride, 0, limit)}
+	for rows.Next() {
+		r, err := scanRide(rows)
+		if err != nil {
+			return Page{}, err
+		}
+		if len(out.Rides) == limit {
+			out.NextCursor = encodeCursor(out.Rides[limit-1])
+			break
+		}
+		out.Rides = append(out.Rides, r)
+	}
+	return out, rows.Err()
+}
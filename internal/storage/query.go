@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageRequest asks for up to Limit rides following Cursor, which is the
+// NextCursor of a previous Page (empty for the first page). Results are
+// ordered newest first.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+}
+
+type Page struct {
+	Rides      []*models.Ride `json:"rides"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// StatusQuery selects rides in a status created within [From, To); zero
+// times leave that side of the range open.
+type StatusQuery struct {
+	Status models.RideStatus
+	From   time.Time
+	To     time.Time
+}
+
+// cursor is the (created_at, id) key of the last ride on a page.
+type cursor struct {
+	createdAt time.Time
+	id        string
+}
+
+func (p PageRequest) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return defaultPageSize
+	case p.Limit > maxPageSize:
+		return maxPageSize
+	}
+	return p.Limit
+}
+
+func (p PageRequest) cursor() (*cursor, error) {
+	if p.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor{createdAt: time.Unix(0, nanos).UTC(), id: id}, nil
+}
+
+func encodeCursor(r *models.Ride) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(r.CreatedAt.UnixNano(), 10) + "|" + r.ID))
+}
+
+// before reports whether r sorts after the cursor in newest-first order.
+func (c *cursor) before(r *models.Ride) bool {
+	if c == nil {
+		return true
+	}
+	if !r.CreatedAt.Equal(c.createdAt) {
+		return r.CreatedAt.Before(c.createdAt)
+	}
+	return r.ID < c.id
+}
+
+// paginate orders rides newest first and cuts the page described by req.
+func paginate(rides []*models.Ride, req PageRequest) (Page, error) {
+	c, err := req.cursor()
+	if err != nil {
+		return Page{}, err
+	}
+	sort.Slice(rides, func(i, j int) bool {
+		if !rides[i].CreatedAt.Equal(rides[j].CreatedAt) {
+			return rides[i].CreatedAt.After(rides[j].CreatedAt)
+		}
+		return rides[i].ID > rides[j].ID
+	})
+	limit := req.limit()
+	out := Page{Rides: make([]*models.Ride, 0, limit)}
+	for _, r := range rides {
+		if !c.before(r) {
+			continue
+		}
+		if len(out.Rides) == limit {
+			out.NextCursor = encodeCursor(out.Rides[limit-1])
+			break
+		}
+		out.Rides = append(out.Rides, r)
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/example/ride-matching/internal/models"
+)
+
+func TestMemoryStorePaginatesNewestFirst(t *testing.T) {
+	m := NewMemoryStore()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		status := models.StatusCompleted
+		if i%2 == 1 {
+			status = models.StatusCanceled
+		}
+		// rides 3 and 4 share a timestamp to exercise the id tie-break
+		created := base.Add(time.Duration(min(i, 3)) * time.Minute)
+		_ = m.SaveRide(&models.Ride{ID: fmt.Sprintf("ride%d", i), RiderID: "r1", DriverID: "d1", Status: status, CreatedAt: created})
+	}
+	_ = m.SaveRide(&models.Ride{ID: "other", RiderID: "r2", CreatedAt: base})
+
+	var got []string
+	page := PageRequest{Limit: 2}
+	for {
+		res, err := m.ListRidesByRider("r1", page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range res.Rides {
+			got = append(got, r.ID)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		page.Cursor = res.NextCursor
+	}
+	want := []string{"ride4", "ride3", "ride2", "ride1", "ride0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	res, err := m.ListRidesByStatus(StatusQuery{Status: models.StatusCompleted, From: base.Add(time.Minute)}, PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rides) != 2 || res.Rides[0].ID != "ride4" || res.Rides[1].ID != "ride2" {
+		t.Fatalf("unexpected status page %+v", res.Rides)
+	}
+
+	if _, err := m.ListRidesByDriver("d1", PageRequest{Cursor: "%%%"}); err != ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestMemoryStoreEmitsEventsAndDrainsOutbox(t *testing.T) {
+	m := NewMemoryStore()
+	r := &models.Ride{ID: "ride1", RiderID: "r1", Status: models.StatusRequested}
+	_ = m.SaveRide(r)
+	r.DriverID = "d1"
+	_ = m.UpdateRide(r) // no status change, no event
+	r.Status = models.StatusAccepted
+	_ = m.UpdateRide(r)
+
+	evs := m.Events()
+	if len(evs) != 2 || evs[0].Type != "ride.requested" || evs[1].FromStatus != models.StatusRequested || evs[1].ToStatus != models.StatusAccepted {
+		t.Fatalf("unexpected events %+v", evs)
+	}
+	if evs[1].Ride.DriverID != "d1" {
+		t.Fatalf("expected snapshot to carry driver, got %+v", evs[1].Ride)
+	}
+	r.PaymentStatus = models.PaymentCaptured
+	_ = m.UpdateRide(r)
+	if evs = m.Events(); len(evs) != 3 || evs[2].Type != "ride.payment_captured" || evs[2].Ride.PaymentStatus != models.PaymentCaptured {
+		t.Fatalf("expected a payment event, got %+v", evs)
+	}
+
+	ctx := context.Background()
+	failing := func([]OutboxMessage) error { return errors.New("kafka down") }
+	if _, err := m.DrainOutbox(ctx, 10, failing); err == nil {
+		t.Fatal("expected publish error")
+	}
+	var keys []string
+	n, err := m.DrainOutbox(ctx, 10, func(msgs []OutboxMessage) error {
+		for _, msg := range msgs {
+			keys = append(keys, msg.Key)
+		}
+		return nil
+	})
+	if err != nil || n != 3 || len(keys) != 3 || keys[0] != "ride1" {
+		t.Fatalf("expected failed batch to be redelivered, got n=%d keys=%v err=%v", n, keys, err)
+	}
+	if n, _ := m.DrainOutbox(ctx, 10, failing); n != 0 {
+		t.Fatalf("expected empty outbox, got %d", n)
+	}
+}
@@ -1,20 +1,36 @@
 package storage
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/example/ride-matching/internal/models"
 )
 
+// ErrNotFound is returned when a ride does not exist.
+var ErrNotFound = errors.New("ride not found")
+
 // TripStore defines persistence operations for rides.
 type TripStore interface {
 	SaveRide(r *models.Ride) error
 	UpdateRide(r *models.Ride) error
+	GetRide(id string) (*models.Ride, error)
+	ListRidesByRider(riderID string, page PageRequest) (Page, error)
+	ListRidesByDriver(driverID string, page PageRequest) (Page, error)
+	ListRidesByStatus(q StatusQuery, page PageRequest) (Page, error)
 }
 
+// MemoryStore keeps copies of rides so callers cannot mutate stored state
+// behind the store's back. Like PostgresStore it records a RideEvent and an
+// outbox message for every status or payment status change.
 type MemoryStore struct {
-	mu    sync.RWMutex
-	rides map[string]*models.Ride
+	mu          sync.RWMutex
+	rides       map[string]*models.Ride
+	events      []models.RideEvent
+	outbox      []OutboxMessage
+	nextEventID int64
+	drainMu     sync.Mutex // one DrainOutbox at a time, without blocking ride writes
 }
 
 func NewMemoryStore() *MemoryStore {
@@ -24,20 +40,65 @@ func NewMemoryStore() *MemoryStore {
 func (m *MemoryStore) SaveRide(r *models.Ride) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.rides[r.ID] = r
+	cp := *r
+	m.rides[r.ID] = &cp
+	if e, ok := newRideEvent("", "", &cp, time.Now()); ok {
+		m.recordEvent(e)
+	}
 	return nil
 }
 
 func (m *MemoryStore) UpdateRide(r *models.Ride) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.rides[r.ID] = r
+	prev, ok := m.rides[r.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	cp := *r
+	m.rides[r.ID] = &cp
+	if e, ok := newRideEvent(prev.Status, prev.PaymentStatus, &cp, time.Now()); ok {
+		m.recordEvent(e)
+	}
 	return nil
 }
 
-func (m *MemoryStore) Get(id string) (*models.Ride, bool) {
+func (m *MemoryStore) GetRide(id string) (*models.Ride, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	r, ok := m.rides[id]
-	return r, ok
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *r
+	return &cp, nil
+}
+
+func (m *MemoryStore) ListRidesByRider(riderID string, page PageRequest) (Page, error) {
+	return m.list(page, func(r *models.Ride) bool { return r.RiderID == riderID })
+}
+
+func (m *MemoryStore) ListRidesByDriver(driverID string, page PageRequest) (Page, error) {
+	return m.list(page, func(r *models.Ride) bool { return r.DriverID == driverID })
+}
+
+func (m *MemoryStore) ListRidesByStatus(q StatusQuery, page PageRequest) (Page, error) {
+	return m.list(page, func(r *models.Ride) bool {
+		return r.Status == q.Status &&
+			(q.From.IsZero() || !r.CreatedAt.Before(q.From)) &&
+			(q.To.IsZero() || r.CreatedAt.Before(q.To))
+	})
+}
+
+func (m *MemoryStore) list(page PageRequest, match func(r *models.Ride) bool) (Page, error) {
+	m.mu.RLock()
+	var rides []*models.Ride
+	for _, r := range m.rides {
+		if match(r) {
+			cp := *r
+			rides = append(rides, &cp)
+		}
+	}
+	m.mu.RUnlock()
+	return paginate(rides, page)
 }
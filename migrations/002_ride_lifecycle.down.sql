@@ -0,0 +1 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS canceled_by;
@@ -0,0 +1,2 @@
+-- who canceled a ride (rider, driver or system)
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS canceled_by TEXT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_rides_status_created;
+DROP INDEX IF EXISTS idx_rides_driver_created;
+DROP INDEX IF EXISTS idx_rides_rider_created;
@@ -0,0 +1,4 @@
+-- keyset pagination for ride history (newest first)
+CREATE INDEX IF NOT EXISTS idx_rides_rider_created ON rides(rider_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_rides_driver_created ON rides(driver_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_rides_status_created ON rides(status, created_at DESC, id DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ride_outbox;
+DROP TABLE IF EXISTS ride_events;
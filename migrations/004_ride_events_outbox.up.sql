@@ -0,0 +1,22 @@
+-- append-only log of ride state changes
+CREATE TABLE IF NOT EXISTS ride_events (
+  id BIGSERIAL PRIMARY KEY,
+  ride_id TEXT NOT NULL,
+  type TEXT NOT NULL,
+  from_status TEXT,
+  to_status TEXT NOT NULL,
+  ride JSONB NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ride_events_ride ON ride_events(ride_id, id);
+
+-- transactional outbox drained by the ride-events relay
+CREATE TABLE IF NOT EXISTS ride_outbox (
+  id BIGSERIAL PRIMARY KEY,
+  event_id BIGINT NOT NULL REFERENCES ride_events(id),
+  key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  sent_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS idx_ride_outbox_unsent ON ride_outbox(id) WHERE sent_at IS NULL;
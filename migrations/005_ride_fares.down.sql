@@ -0,0 +1,3 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS surge_multiplier;
+ALTER TABLE rides DROP COLUMN IF EXISTS fare_currency;
+ALTER TABLE rides DROP COLUMN IF EXISTS fare_amount;
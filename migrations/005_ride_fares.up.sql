@@ -0,0 +1,4 @@
+-- price locked when the ride was requested, in minor currency units
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS fare_amount BIGINT;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS fare_currency TEXT;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS surge_multiplier DOUBLE PRECISION;
@@ -0,0 +1,3 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS payment_status;
+ALTER TABLE rides DROP COLUMN IF EXISTS payment_intent_id;
+ALTER TABLE rides DROP COLUMN IF EXISTS cancellation_fee;
@@ -0,0 +1,4 @@
+-- card hold placed when the ride was requested
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS cancellation_fee BIGINT;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS payment_intent_id TEXT;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS payment_status TEXT;
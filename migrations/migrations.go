@@ -0,0 +1,9 @@
+// Package migrations embeds the versioned SQL migrations so the binary can
+// apply them without access to the source tree. Files are named
+// NNN_description.up.sql / NNN_description.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS